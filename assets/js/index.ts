@@ -10,21 +10,3 @@ import 'htmx.org';
   }
 
 }
-
-(window as any).parseTokens = function() {
-  const hash = window.location.hash.substring(1);
-  if (!hash) {
-    const result = document.getElementById('result');
-    if (result) {
-      result.textContent = 'No authentication data found in URL.';
-    }
-    return {};
-  }
-  const tokens = {};
-  hash.split('&').forEach(part => {
-    const [key, value] = part.split('=');
-    tokens[key] = decodeURIComponent(value);
-  });
-  return tokens;
-};
-
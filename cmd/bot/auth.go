@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/api/shared"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/tokenstore"
+	"github.com/OleksandrOleniuk/twitchong/internal/websocket"
+	"go.uber.org/zap"
+)
+
+// account is a Twitch user the bot acts as
+type account struct {
+	identity helix.Identity
+	userID   string
+	// scopes the account's token must have
+	scopes []string
+}
+
+// configuredAccounts lists the bot, the broadcaster of the chat channel and
+// the optional moderator. An account of the same user as an earlier one is
+// left out; calls for that user use the earlier account's token.
+func configuredAccounts() []account {
+	accounts := []account{{
+		identity: helix.IdentityBot,
+		userID:   appConfig.BotUserId,
+		scopes:   []string{"user:read:chat", "user:write:chat"},
+	}}
+
+	if appConfig.ChatChannelUserId != appConfig.BotUserId {
+		accounts = append(accounts, account{identity: helix.IdentityBroadcaster, userID: appConfig.ChatChannelUserId})
+	}
+
+	moderator := appConfig.ModeratorUserId
+	if moderator != "" && moderator != appConfig.BotUserId && moderator != appConfig.ChatChannelUserId {
+		accounts = append(accounts, account{identity: helix.IdentityModerator, userID: moderator})
+	}
+
+	return accounts
+}
+
+// authManager keeps a user token for every account, from the token store or
+// from browser logins, on one Helix client that picks the account per call
+type authManager struct {
+	store    tokenstore.Store
+	helix    *helix.Client
+	accounts []account
+
+	botReady     chan struct{}
+	botReadyOnce sync.Once
+
+	mu         sync.Mutex
+	validators map[helix.Identity]*helix.Validator
+	// botLogin is the login of the bot's token once it was validated
+	botLogin string
+}
+
+func newAuthManager(store tokenstore.Store) *authManager {
+	return &authManager{
+		store:      store,
+		helix:      helix.New(appConfig),
+		accounts:   configuredAccounts(),
+		botReady:   make(chan struct{}),
+		validators: make(map[helix.Identity]*helix.Validator),
+	}
+}
+
+// openTokenStore opens the encrypted token store, or returns nil when no
+// TOKEN_STORE_KEY is configured
+func openTokenStore() tokenstore.Store {
+	if appConfig.TokenStoreKey == "" {
+		logger.Info("TOKEN_STORE_KEY is not set, OAuth tokens are not kept between runs")
+		return nil
+	}
+
+	key, err := tokenstore.ParseKey(appConfig.TokenStoreKey)
+	if err != nil {
+		logger.Error("invalid token store key", zap.Error(err))
+		return nil
+	}
+
+	store, err := tokenstore.NewFileStore(appConfig.TokenStoreFile, key)
+	if err != nil {
+		logger.Error("failed to open token store", zap.Error(err))
+		return nil
+	}
+	return store
+}
+
+// loadStoredTokens uses the stored token of every account, unless Twitch
+// rejects it
+func (a *authManager) loadStoredTokens() {
+	if a.store == nil {
+		return
+	}
+
+	for _, acc := range a.accounts {
+		token, err := a.store.Load(acc.userID)
+		if errors.Is(err, tokenstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			logger.Error("failed to load stored OAuth token", zap.String("identity", string(acc.identity)), zap.Error(err))
+			continue
+		}
+		if token.Expired() && token.RefreshToken == "" {
+			continue
+		}
+
+		a.setToken(acc, token)
+
+		// Only a rejected token needs a new login, Twitch may just be unreachable
+		validation, err := validateOAuthToken(a.helix, acc.identity)
+		if errors.Is(err, helix.ErrUnauthorized) {
+			logger.Warn("stored OAuth token is no longer valid, log in with Twitch again", zap.String("identity", string(acc.identity)))
+			a.helix.RemoveIdentity(acc.identity)
+			a.deleteToken(acc.userID)
+			continue
+		}
+		if validation != nil {
+			a.rememberLogin(acc, validation.Login)
+		}
+
+		logger.Info("using stored OAuth token", zap.String("identity", string(acc.identity)), zap.String("user_id", acc.userID))
+		if acc.identity == helix.IdentityBot {
+			a.botReadyOnce.Do(func() { close(a.botReady) })
+		}
+	}
+}
+
+// receiveLogins gives the token of every browser login to the account of the
+// user who logged in
+func (a *authManager) receiveLogins() {
+	for token := range shared.OAuthTokenChan {
+		a.applyLogin(token)
+	}
+}
+
+func (a *authManager) applyLogin(token helix.Token) {
+	logger.Info("received OAuth token, proceeding with validation")
+
+	validation, err := validateOAuthToken(helix.New(appConfig, helix.WithUserToken(token, nil)), "")
+	if err != nil {
+		shared.SetAuthAlert("Could not validate the Twitch login, please try again")
+		return
+	}
+
+	acc, ok := a.account(validation.UserID)
+	if !ok {
+		logger.Warn("logged in user is none of BOT_USER_ID, CHAT_CHANNEL_USER_ID or MODERATOR_USER_ID",
+			zap.String("user", validation.Login),
+			zap.String("user_id", validation.UserID),
+		)
+		shared.SetAuthAlert("Log in with the bot's, the broadcaster's or the moderator's Twitch account")
+		return
+	}
+
+	a.setToken(acc, token)
+	a.saveToken(acc.userID, token)
+	a.rememberLogin(acc, validation.Login)
+	logger.Info("logged in with Twitch", zap.String("identity", string(acc.identity)), zap.String("user", validation.Login))
+
+	if acc.identity == helix.IdentityBot {
+		a.botReadyOnce.Do(func() { close(a.botReady) })
+	}
+
+	a.mu.Lock()
+	validator := a.validators[acc.identity]
+	a.mu.Unlock()
+	if validator != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		validator.Check(ctx)
+	}
+}
+
+// rememberLogin keeps the login of the bot's validated token
+func (a *authManager) rememberLogin(acc account, login string) {
+	if acc.identity != helix.IdentityBot {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.botLogin = login
+}
+
+// validatedBotLogin returns the login of the bot's token, or "" when it
+// could not be validated yet
+func (a *authManager) validatedBotLogin() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.botLogin
+}
+
+// waitForBot blocks until the bot account has a token
+func (a *authManager) waitForBot() {
+	select {
+	case <-a.botReady:
+	default:
+		logger.Info("waiting for OAuth token from callback", zap.String("user_id", appConfig.BotUserId))
+		<-a.botReady
+	}
+}
+
+// watch validates every account's token hourly, as Twitch requires. While
+// the bot's token is unusable chat messages are held. For any account the
+// streamer is asked to authorize again, and the next login replaces the token.
+func (a *authManager) watch(chat *websocket.Client) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, acc := range a.accounts {
+		validator := helix.NewValidator(a.helix,
+			helix.WithValidatedIdentity(acc.identity),
+			helix.WithRequiredScopes(acc.scopes...),
+			helix.WithOnValidation(func(event helix.ValidationEvent) {
+				a.handleValidation(chat, acc, event)
+			}),
+		)
+		validator.Start()
+		a.validators[acc.identity] = validator
+	}
+}
+
+// handleValidation holds or resumes chat for the bot's token and keeps the
+// dashboard alert of the account's token, so a valid token of one account
+// does not hide a problem with another's
+func (a *authManager) handleValidation(chat *websocket.Client, acc account, event helix.ValidationEvent) {
+	isBot := acc.identity == helix.IdentityBot
+	identity := string(acc.identity)
+	prefix := "Your " + identity + " account's Twitch token "
+
+	// Chatters mention the bot by the login of its token, unless BOT_LOGIN is set
+	if isBot && event.Validation != nil && event.Validation.Login != "" && appConfig.BotLogin == "" {
+		a.rememberLogin(acc, event.Validation.Login)
+		chat.SetBotLogin(event.Validation.Login)
+	}
+
+	switch event.Status {
+	case helix.TokenValid:
+		if isBot {
+			chat.ResumeChat()
+		}
+		shared.SetIdentityAlert(identity, "")
+	case helix.TokenExpiring:
+		// The token still works until it expires
+		if event.Err != nil {
+			shared.SetIdentityAlert(identity, prefix+"expires soon and could not be refreshed - re-authorize with Twitch")
+		} else {
+			shared.SetIdentityAlert(identity, "")
+		}
+	case helix.TokenMismatch:
+		if isBot {
+			chat.PauseChat()
+		}
+		shared.SetIdentityAlert(identity, prefix+"is missing scopes: "+strings.Join(event.MissingScopes, ", ")+" - re-authorize with Twitch")
+	case helix.TokenRevoked:
+		// Refreshing failed too, so the stored token is useless on the next start
+		a.deleteToken(acc.userID)
+		if isBot {
+			chat.PauseChat()
+		}
+		shared.SetIdentityAlert(identity, prefix+"was revoked - re-authorize with Twitch")
+	case helix.TokenMissing:
+		if isBot {
+			chat.PauseChat()
+		}
+		shared.SetIdentityAlert(identity, prefix+"is missing - log in with Twitch as the "+identity)
+	}
+}
+
+// stop stops validating the tokens
+func (a *authManager) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, validator := range a.validators {
+		validator.Stop()
+	}
+}
+
+func (a *authManager) account(userID string) (account, bool) {
+	for _, acc := range a.accounts {
+		if acc.userID == userID {
+			return acc, true
+		}
+	}
+	return account{}, false
+}
+
+// setToken authorizes an account's calls with a token, storing every refresh
+func (a *authManager) setToken(acc account, token helix.Token) {
+	a.helix.SetIdentity(acc.identity, acc.userID, token, func(token helix.Token) {
+		logger.Info("access token refreshed", zap.String("identity", string(acc.identity)), zap.Time("expires_at", token.ExpiresAt))
+		a.saveToken(acc.userID, token)
+	})
+}
+
+func (a *authManager) saveToken(userID string, token helix.Token) {
+	if a.store == nil {
+		return
+	}
+	if err := a.store.Save(userID, token); err != nil {
+		logger.Error("failed to store OAuth token", zap.Error(err))
+	}
+}
+
+// deleteToken removes a token Twitch rejected from the store
+func (a *authManager) deleteToken(userID string) {
+	if a.store == nil {
+		return
+	}
+	if err := a.store.Delete(userID); err != nil {
+		logger.Error("failed to delete OAuth token", zap.Error(err))
+	}
+}
+
+// validateOAuthToken validates the token of an identity, or the client's
+// default token when identity is empty
+func validateOAuthToken(client *helix.Client, identity helix.Identity) (*helix.TokenValidation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if identity != "" {
+		ctx = helix.AsIdentity(ctx, identity)
+	}
+
+	validation, err := client.ValidateToken(ctx)
+	if err != nil {
+		logger.Error("token validation failed", zap.Error(err))
+		return nil, err
+	}
+
+	logger.Info("token validated",
+		zap.String("user", validation.Login),
+		zap.Strings("scopes", validation.Scopes),
+	)
+	return validation, nil
+}
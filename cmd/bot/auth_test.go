@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/api/shared"
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix/helixtest"
+	"github.com/OleksandrOleniuk/twitchong/internal/tokenstore"
+)
+
+func TestApplyLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		userID   string
+		login    string
+		identity helix.Identity // "" when no account matches
+	}{
+		{"bot", "1", "bot", helix.IdentityBot},
+		{"broadcaster", "2", "streamer", helix.IdentityBroadcaster},
+		{"moderator", "3", "mod", helix.IdentityModerator},
+		{"someone else", "9", "viewer", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := helixtest.NewServer()
+			defer fake.Close()
+			appConfig = &config.Config{ClientId: "client-id", BotUserId: "1", ChatChannelUserId: "2", ModeratorUserId: "3"}
+			fake.Configure(appConfig)
+			t.Cleanup(func() { shared.SetAuthAlert("") })
+
+			store, err := tokenstore.NewFileStore(filepath.Join(t.TempDir(), "tokens"), bytes.Repeat([]byte{1}, tokenstore.KeySize))
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth := newAuthManager(store)
+
+			// Twitch tells who logged in by validating the new token
+			fake.Enqueue(http.MethodGet, "/oauth2/validate", helixtest.Response{
+				Status: http.StatusOK,
+				Body:   helixtest.TokenInfo{ClientID: "client-id", Login: tt.login, UserID: tt.userID, ExpiresIn: 3600},
+			})
+			auth.applyLogin(helix.Token{AccessToken: "new-token"})
+
+			for _, identity := range []helix.Identity{helix.IdentityBot, helix.IdentityBroadcaster, helix.IdentityModerator} {
+				if got := auth.helix.HasIdentity(identity); got != (identity == tt.identity) {
+					t.Errorf("%s has a token: %v", identity, got)
+				}
+			}
+
+			stored, err := store.Load(tt.userID)
+			if (err == nil) != (tt.identity != "") || (err == nil && stored.AccessToken != "new-token") {
+				t.Errorf("stored %+v, %v", stored, err)
+			}
+
+			select {
+			case <-auth.botReady:
+				if tt.identity != helix.IdentityBot {
+					t.Error("bot ready after another account logged in")
+				}
+			default:
+				if tt.identity == helix.IdentityBot {
+					t.Error("bot not ready after it logged in")
+				}
+			}
+			if tt.identity == helix.IdentityBot && auth.validatedBotLogin() != tt.login {
+				t.Errorf("bot login %q, want %q", auth.validatedBotLogin(), tt.login)
+			}
+
+			if alerts := shared.AuthAlerts(); (len(alerts) > 0) != (tt.identity == "") {
+				t.Errorf("alerts %v", alerts)
+			}
+		})
+	}
+}
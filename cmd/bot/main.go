@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -11,6 +12,10 @@ import (
 	"github.com/OleksandrOleniuk/twitchong/internal/api/server"
 	"github.com/OleksandrOleniuk/twitchong/internal/api/shared"
 	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/recording"
+	"github.com/OleksandrOleniuk/twitchong/internal/webhook"
 	"github.com/OleksandrOleniuk/twitchong/internal/websocket"
 	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
 
@@ -21,11 +26,19 @@ var (
 	appConfig          *config.Config
 	websocketSessionID = ""
 	shutdownChan       = make(chan struct{})
+	shutdownOnce       sync.Once
 	wg                 sync.WaitGroup
 	logger             = utils.With(zap.String("component", "main"))
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "subscriptions":
+			os.Exit(runSubscriptionsCommand(os.Args[2:]))
+		}
+	}
+
 	var appConfigErr error
 	appConfig, appConfigErr = config.Load()
 
@@ -33,8 +46,33 @@ func main() {
 		logger.Error("Failed to load app config")
 	}
 
+	// Handlers are shared by the websocket client and the webhook callbacks
+	dispatcher := eventsub.NewDispatcher()
+
+	var serverOptions []server.Option
+	var webhookHandler *webhook.Handler
+	if appConfig.EventsubTransport == "webhook" {
+		// Like the websocket client, messages from the same chatter wait for
+		// each other while other chatters are handled in parallel
+		var err error
+		webhookHandler, err = webhook.New(appConfig, dispatcher,
+			webhook.WithOnLifecycle(handleLifecycle),
+			webhook.WithProcessor(eventsub.ProcessorConfig{
+				Mode:      eventsub.ProcessByLane,
+				Workers:   4,
+				QueueSize: 256,
+				Overflow:  eventsub.OverflowDropOldest,
+				Lane:      eventsub.LaneByUser,
+			}),
+		)
+		if err != nil {
+			logger.Fatal("cannot receive EventSub webhooks, set WEBHOOK_SECRET", zap.Error(err))
+		}
+		serverOptions = append(serverOptions, server.WithWebhook(webhookHandler))
+	}
+
 	// Initialize the server
-	srv := server.New(appConfig)
+	srv := server.New(appConfig, serverOptions...)
 
 	// Start the server in a goroutine
 	wg.Add(1)
@@ -43,38 +81,78 @@ func main() {
 		if err := server.Start(srv, appConfig); err != nil {
 			logger.Error("server error", zap.Error(err))
 			// If server fails to start, initiate graceful shutdown
-			close(shutdownChan)
+			requestShutdown()
 		}
 	}()
 
 	// Give the server a moment to start or fail
 	time.Sleep(100 * time.Millisecond)
 
-	// Wait for OAuth token from callback
-	logger.Info("waiting for OAuth token from callback")
-	accessToken := <-shared.OAuthTokenChan
-	logger.Info("received OAuth token, proceeding with validation")
+	// Every user API call picks the token of the bot, broadcaster or
+	// moderator account, refreshed before it expires or when Twitch rejects
+	// it. Stored tokens save the browser login.
+	auth := newAuthManager(openTokenStore())
+	auth.loadStoredTokens()
+	go auth.receiveLogins()
+	auth.waitForBot()
+	userHelix := auth.helix
 
-	// Update config with new access token
-	appConfig.OauthToken = accessToken
+	// Delete subscriptions left behind by previous runs, they count towards the cost limit
+	pruneStaleSubscriptions(userHelix)
 
-	// Validate OAuth token
-	validateOAuthToken()
+	// Raw frames are recorded for cmd/replay when a record file is configured
+	var frameOptions []websocket.ClientOption
+	if appConfig.EventsubRecordFile != "" {
+		recorder, err := recording.Create(appConfig.EventsubRecordFile)
+		if err != nil {
+			logger.Error("failed to open recording", zap.Error(err))
+		} else {
+			defer recorder.Close()
+			frameOptions = append(frameOptions, websocket.WithOnFrame(recorder.Record))
+		}
+	}
 
-	chat := websocket.NewTwitchChat(appConfig)
+	chat := websocket.NewTwitchChat(appConfig, append([]websocket.ClientOption{
+		websocket.WithDispatcher(dispatcher),
+		websocket.WithOnLifecycle(handleLifecycle),
+		websocket.WithHelix(userHelix),
+	}, frameOptions...)...)
 
-	// Start the ws in a goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := websocket.StartTwitchChat(chat); err != nil {
-			logger.Error("server error", zap.Error(err))
-			close(shutdownChan)
+	// Chatters mention the bot by the login of its token, unless BOT_LOGIN is set
+	if appConfig.BotLogin == "" {
+		if login := auth.validatedBotLogin(); login != "" {
+			chat.SetBotLogin(login)
+		} else {
+			logger.Warn("bot login unknown until its token is validated, only replies to the bot are answered; set BOT_LOGIN to avoid this")
 		}
-	}()
+	}
 
-	// Give the ws a moment to start or fail
-	time.Sleep(100 * time.Millisecond)
+	// Twitch requires validating user tokens hourly; chat waits while the bot's token is unusable
+	auth.watch(chat)
+	defer auth.stop()
+
+	var conduit *websocket.Conduit
+	switch {
+	case webhookHandler != nil:
+		// Notifications arrive on the server, the websocket is not needed
+		subscribeWebhooks(webhookHandler, chat.Subscriptions())
+	case appConfig.EventsubTransport == "conduit":
+		// The shards share the chat client's handlers instead of its connection
+		conduit = startConduit(dispatcher, chat.Subscriptions(), frameOptions)
+	default:
+		// Start the ws in a goroutine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := websocket.StartTwitchChat(chat); err != nil {
+				logger.Error("server error", zap.Error(err))
+				requestShutdown()
+			}
+		}()
+
+		// Give the ws a moment to start or fail
+		time.Sleep(100 * time.Millisecond)
+	}
 
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
@@ -90,38 +168,96 @@ func main() {
 
 	// Initiate graceful shutdown
 	fmt.Println("Shutting down...")
-	close(shutdownChan)
+	requestShutdown()
+
+	// Stop receiving events first, then let the queued ones be handled and
+	// the queued chat replies be sent while the server is still up
+	if webhookHandler != nil {
+		webhookHandler.Stop()
+	}
+	if conduit != nil {
+		conduit.Stop()
+	}
+	chat.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("failed to shut down server", zap.Error(err))
+	}
 
 	// Wait for all goroutines to complete
 	wg.Wait()
 	fmt.Println("Shutdocomplete")
 }
 
-func validateOAuthToken() {
-	config := utils.RequestConfig{
-		Method: "GET",
-		URL:    "https://id.twitch.tv/oauth2/validate",
-		Headers: map[string]string{
-			"Authorization": "OAuth " + appConfig.OauthToken,
-		},
-	}
+// requestShutdown wakes main to shut down; it is safe to call more than once
+func requestShutdown() {
+	shutdownOnce.Do(func() { close(shutdownChan) })
+}
 
-	var validation struct {
-		ClientID  string   `json:"client_id"`
-		Login     string   `json:"login"`
-		Scopes    []string `json:"scopes"`
-		UserID    string   `json:"user_id"`
-		ExpiresIn int      `json:"expires_in"`
+// handleLifecycle asks for re-authorization when Twitch revokes a
+// subscription because the bot lost access
+func handleLifecycle(event eventsub.LifecycleEvent) {
+	if event.Type == eventsub.LifecycleRevoked && event.Reason == eventsub.RevocationAuthorizationRevoked {
+		shared.SetAuthAlert("Your bot lost access to " + event.Request.Type + " - re-authorize with Twitch")
 	}
+}
+
+// subscribeWebhooks creates the configured subscriptions with the webhook
+// transport, which requires an app access token
+func subscribeWebhooks(handler *webhook.Handler, requests []eventsub.SubscriptionRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	err := utils.SendRequestAndParseResponse(config, &validation)
+	appHelix, err := helix.NewAppClient(ctx, appConfig)
 	if err != nil {
-		logger.Error("token validation failed", zap.Error(err))
+		logger.Error("failed to get app access token", zap.Error(err))
 		return
 	}
 
-	logger.Info("token validated",
-		zap.String("user", validation.Login),
-		zap.Strings("scopes", validation.Scopes),
+	if err := handler.Subscribe(ctx, appHelix, requests); err != nil {
+		logger.Error("failed to create webhook subscriptions", zap.Error(err))
+	}
+}
+
+// startConduit connects the configured number of conduit shards and creates
+// the subscriptions on the conduit
+func startConduit(dispatcher *eventsub.Dispatcher, requests []eventsub.SubscriptionRequest, shardOptions []websocket.ClientOption) *websocket.Conduit {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	appHelix, err := helix.NewAppClient(ctx, appConfig)
+	if err != nil {
+		logger.Error("failed to get app access token", zap.Error(err))
+		return nil
+	}
+
+	conduit := websocket.NewConduit(appConfig, appHelix,
+		websocket.WithConduitID(appConfig.ConduitId),
+		websocket.WithShardCount(appConfig.ConduitShards),
+		websocket.WithConduitDispatcher(dispatcher),
+		websocket.WithConduitOnLifecycle(handleLifecycle),
+		websocket.WithShardOptions(shardOptions...),
 	)
+	for _, request := range requests {
+		conduit.Subscribe(request)
+	}
+
+	if err := conduit.Start(); err != nil {
+		logger.Error("failed to start conduit", zap.Error(err))
+	}
+
+	logger.Info("conduit started", zap.String("conduit_id", conduit.ID()))
+	return conduit
+}
+
+func pruneStaleSubscriptions(userHelix *helix.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	manager := helix.NewSubscriptionManager(userHelix)
+	if _, err := manager.Prune(ctx, helix.PruneOptions{}); err != nil {
+		logger.Error("failed to prune stale subscriptions", zap.Error(err))
+	}
 }
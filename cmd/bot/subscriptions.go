@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"go.uber.org/zap"
+)
+
+const subscriptionsUsage = `usage: bot subscriptions <command> [flags]
+
+Inspects the application's EventSub subscriptions. Webhook and conduit
+subscriptions are read with an app access token, websocket subscriptions with
+the bot's token from the token store.
+
+commands:
+  list     list subscriptions (-status, -type)
+  report   show subscription counts and cost
+  prune    delete subscriptions that no longer deliver notifications (-dry-run, -foreign)
+`
+
+// errNoBotToken is returned when the token store has no usable bot token
+var errNoBotToken = errors.New("no stored token for BOT_USER_ID, run the bot with TOKEN_STORE_KEY set and log in with Twitch first")
+
+// runSubscriptionsCommand implements the "subscriptions" subcommand and
+// returns the process exit code
+func runSubscriptionsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, subscriptionsUsage)
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load app config: %v\n", err)
+		return 1
+	}
+	appConfig = cfg
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client, err := subscriptionsClient(ctx)
+	if err != nil {
+		logger.Error("failed to authorize with Twitch", zap.Error(err))
+		return 1
+	}
+	manager := helix.NewSubscriptionManager(client)
+
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("list", flag.ContinueOnError)
+		status := flags.String("status", "", "only list subscriptions with this status")
+		subscriptionType := flags.String("type", "", "only list subscriptions of this type")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		subscriptions, err := manager.List(ctx, helix.SubscriptionFilter{Status: *status, Type: *subscriptionType})
+		if err != nil {
+			logger.Error("failed to list subscriptions", zap.Error(err))
+			return 1
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTYPE\tVERSION\tSTATUS\tCOST\tTRANSPORT\tCREATED")
+		for _, s := range subscriptions {
+			transport := s.Transport.Method
+			if s.Transport.SessionID != "" {
+				transport += ":" + s.Transport.SessionID
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+				s.ID, s.Type, s.Version, s.Status, s.Cost, transport, s.CreatedAt.Format(time.RFC3339))
+		}
+		w.Flush()
+
+	case "report":
+		report, err := manager.Report(ctx)
+		if err != nil {
+			logger.Error("failed to report subscriptions", zap.Error(err))
+			return 1
+		}
+		printSubscriptionReport(report)
+
+	case "prune":
+		flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+		dryRun := flags.Bool("dry-run", false, "only show what would be deleted")
+		foreign := flags.Bool("foreign", false, "also delete enabled websocket subscriptions of other sessions")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		result, err := manager.Prune(ctx, helix.PruneOptions{DryRun: *dryRun, ForeignSessions: *foreign})
+		if err != nil {
+			logger.Error("failed to prune subscriptions", zap.Error(err))
+			return 1
+		}
+
+		verb := "deleted"
+		if *dryRun {
+			verb = "would delete"
+		}
+		for _, s := range result.Deleted {
+			fmt.Printf("%s %s %s (%s)\n", verb, s.ID, s.Type, s.Status)
+		}
+		for _, s := range result.Failed {
+			fmt.Printf("failed to delete %s %s (%s)\n", s.ID, s.Type, s.Status)
+		}
+		printSubscriptionReport(result.Report)
+
+		if len(result.Failed) > 0 {
+			return 1
+		}
+
+	default:
+		fmt.Fprint(os.Stderr, subscriptionsUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// subscriptionsClient returns a client that sees the subscriptions of the
+// configured transport: Twitch lists webhook and conduit subscriptions for the
+// app, and websocket subscriptions for the user who created them
+func subscriptionsClient(ctx context.Context) (*helix.Client, error) {
+	if appConfig.EventsubTransport == "webhook" || appConfig.EventsubTransport == "conduit" {
+		return helix.NewAppClient(ctx, appConfig)
+	}
+
+	auth := newAuthManager(openTokenStore())
+	auth.loadStoredTokens()
+	if !auth.helix.HasIdentity(helix.IdentityBot) {
+		return nil, errNoBotToken
+	}
+	return auth.helix, nil
+}
+
+func printSubscriptionReport(report helix.SubscriptionReport) {
+	fmt.Printf("total: %d, cost: %d/%d\n", report.Total, report.TotalCost, report.MaxTotalCost)
+	for status, count := range report.ByStatus {
+		fmt.Printf("  status %s: %d\n", status, count)
+	}
+	for subscriptionType, count := range report.ByType {
+		fmt.Printf("  type %s: %d\n", subscriptionType, count)
+	}
+}
@@ -0,0 +1,103 @@
+// Command replay replays EventSub frames recorded by the bot through its chat
+// handlers. It is a separate binary so the fakes it uses stay out of the bot.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/chat"
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix/helixtest"
+	"github.com/OleksandrOleniuk/twitchong/internal/recording"
+	"github.com/OleksandrOleniuk/twitchong/internal/websocket"
+	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var logger = utils.With(zap.String("component", "replay"))
+
+const replayUsage = `usage: replay [flags] <recording.jsonl>
+
+Replays EventSub frames recorded with EVENTSUB_RECORD_FILE through the chat
+handlers. Chat messages go to a fake Helix API and are printed, unless -live.
+
+flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run replays the recording named in args and returns the process exit code
+func run(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, replayUsage)
+		flags.PrintDefaults()
+	}
+	speed := flags.Float64("speed", 0, "replay speed: 1 is real time, 10 is ten times faster, 0 is instant")
+	live := flags.Bool("live", false, "send chat messages to Twitch instead of a fake")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load app config: %v\n", err)
+		return 1
+	}
+
+	entries, err := recording.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var fake *helixtest.Server
+	options := []websocket.ClientOption{}
+	if !*live {
+		fake = helixtest.NewServer()
+		defer fake.Close()
+		fake.Configure(cfg)
+		// The fake has no chat limits, so replies need not wait for the real ones
+		options = append(options, websocket.WithChatQueue(chat.WithRateLimit(chat.VerifiedLimit)))
+	}
+
+	// The chat client is only used to register the handlers and send their
+	// replies, it never connects
+	dispatcher := eventsub.NewDispatcher()
+	client := websocket.NewTwitchChat(cfg, append(options, websocket.WithDispatcher(dispatcher))...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := recording.Replay(ctx, entries, recording.Speed(*speed), dispatcher)
+	if err != nil {
+		logger.Error("replay interrupted", zap.Error(err))
+	}
+
+	// Stopping waits for the queued chat replies
+	client.Stop()
+
+	if fake != nil {
+		for _, message := range fake.Messages() {
+			fmt.Printf("sent: %s\n", message.Message)
+		}
+	}
+	fmt.Printf("frames: %d, handled: %d, skipped: %d, errors: %d\n", stats.Frames, stats.Handled, stats.Skipped, stats.Errors)
+
+	if err != nil || stats.Errors > 0 {
+		return 1
+	}
+	return 0
+}
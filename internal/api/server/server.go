@@ -7,7 +7,9 @@ import (
 
 	"github.com/OleksandrOleniuk/twitchong/internal/api/handlers"
 	"github.com/OleksandrOleniuk/twitchong/internal/api/middleware"
+	"github.com/OleksandrOleniuk/twitchong/internal/api/shared"
 	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/webhook"
 	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
 	"github.com/OleksandrOleniuk/twitchong/views"
 	"github.com/labstack/echo/v4"
@@ -18,7 +20,17 @@ var (
 	logger = utils.With(zap.String("component", "server"))
 )
 
-func New(config *config.Config) *echo.Echo {
+// Option defines functional options for configuring the server
+type Option func(*echo.Echo)
+
+// WithWebhook receives EventSub webhook callbacks on the server
+func WithWebhook(handler *webhook.Handler) Option {
+	return func(e *echo.Echo) {
+		handler.Register(e)
+	}
+}
+
+func New(config *config.Config, options ...Option) *echo.Echo {
 	e := echo.New()
 
 	// Middleware
@@ -27,14 +39,22 @@ func New(config *config.Config) *echo.Echo {
 	e.File("/index.js", "assets/js/index.js")
 	e.File("/index.min.css", "assets/js/index.min.css")
 
-	e.GET("/twitch/callback", handlers.HandleTwitchCallback)
-	e.POST("/process-tokens", handlers.ProcessTokens)
+	e.GET("/twitch/callback", handlers.HandleTwitchCallback(config))
 
 	e.GET("/", func(c echo.Context) error {
 		handlers.SetState(config.TwitchSecretState, true)
-		return utils.TemplRender(c, http.StatusOK, views.IndexPage(config.ClientId, config.TwitchSecretState))
+		return utils.TemplRender(c, http.StatusOK, views.IndexPage(views.AuthorizeForm{
+			AuthorizeUrl: config.IdBaseUrl + "/oauth2/authorize",
+			ClientId:     config.ClientId,
+			RedirectUri:  config.OauthRedirectUrl,
+			State:        config.TwitchSecretState,
+		}, shared.AuthAlerts()))
 	})
 
+	for _, option := range options {
+		option(e)
+	}
+
 	return e
 }
 
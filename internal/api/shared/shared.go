@@ -1,5 +1,50 @@
 package shared
 
-// OAuthTokenChan is a channel used to pass the OAuth token from the callback handler to the main goroutine
-var OAuthTokenChan = make(chan string, 1)
+import (
+	"maps"
+	"slices"
+	"sync"
 
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+)
+
+// OAuthTokenChan is a channel used to pass the tokens from the callback handler to the main goroutine
+var OAuthTokenChan = make(chan helix.Token, 1)
+
+var (
+	alertMu    sync.RWMutex
+	authAlerts = make(map[string]string)
+)
+
+// SetAuthAlert stores a message for the streamer that is shown on the dashboard,
+// e.g. when the Twitch login failed. An empty message clears it.
+func SetAuthAlert(message string) {
+	SetIdentityAlert("", message)
+}
+
+// SetIdentityAlert stores a message about the token of one identity, e.g.
+// "broadcaster", shown next to the alerts of the other identities. An empty
+// message clears it.
+func SetIdentityAlert(identity, message string) {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	if message == "" {
+		delete(authAlerts, identity)
+		return
+	}
+	authAlerts[identity] = message
+}
+
+// AuthAlerts returns the messages set by SetAuthAlert and SetIdentityAlert,
+// the one of SetAuthAlert first and the others by identity
+func AuthAlerts() []string {
+	alertMu.RLock()
+	defer alertMu.RUnlock()
+
+	identities := slices.Sorted(maps.Keys(authAlerts))
+	alerts := make([]string, 0, len(identities))
+	for _, identity := range identities {
+		alerts = append(alerts, authAlerts[identity])
+	}
+	return alerts
+}
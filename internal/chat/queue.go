@@ -0,0 +1,706 @@
+// Package chat sends the bot's chat messages through a single outbound queue
+// that keeps within the Twitch chat rate limits.
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var logger = utils.With(zap.String("component", "chat"))
+
+// Sender delivers a chat message, implemented by *helix.Client
+type Sender interface {
+	SendChatMessage(ctx context.Context, request helix.ChatMessageRequest) (*helix.ChatMessageResult, error)
+}
+
+// Priority orders pending messages of a channel; higher priorities are sent first
+type Priority int
+
+const (
+	// PriorityLow is for fun replies that may wait or be dropped
+	PriorityLow Priority = -1
+	// PriorityNormal is for regular replies
+	PriorityNormal Priority = 0
+	// PriorityHigh is for moderation replies
+	PriorityHigh Priority = 1
+)
+
+// RateLimit allows Messages per sliding window of Per in a single channel
+type RateLimit struct {
+	Messages int
+	Per      time.Duration
+}
+
+// Twitch chat limits, which depend on the bot's role in the channel
+var (
+	RegularLimit   = RateLimit{Messages: 20, Per: 30 * time.Second}
+	ModeratorLimit = RateLimit{Messages: 100, Per: 30 * time.Second}
+	VerifiedLimit  = RateLimit{Messages: 7500, Per: 30 * time.Second}
+)
+
+// LimitForRole returns the limit of a bot role: "regular", "moderator",
+// "broadcaster" or "verified". The broadcaster, a bot chatting from the
+// channel owner's account, has the moderator limit. Unknown roles get the
+// regular limit.
+func LimitForRole(role string) RateLimit {
+	switch role {
+	case "moderator", "broadcaster":
+		return ModeratorLimit
+	case "verified":
+		return VerifiedLimit
+	}
+	return RegularLimit
+}
+
+// DropPolicy decides what happens to a message enqueued on a full queue
+type DropPolicy int
+
+const (
+	// DropNewest rejects the new message with ErrQueueFull
+	DropNewest DropPolicy = iota
+	// DropOldest drops the message that waited longest to make room
+	DropOldest
+	// DropLowestPriority drops the newest message of the lowest priority if it
+	// is lower than the new message's, otherwise rejects the new message
+	DropLowestPriority
+)
+
+// Reasons for messages the queue drops itself; messages Twitch drops carry
+// the drop_reason code of the Helix response instead
+const (
+	DropReasonDuplicate = "duplicate"
+	DropReasonEvicted   = "evicted"
+	DropReasonClosed    = "queue_closed"
+)
+
+// retryableDropCodes are Twitch drop reasons worth sending the message again for
+var retryableDropCodes = map[string]bool{
+	"msg_ratelimit": true,
+	"msg_slowmode":  true,
+}
+
+var (
+	ErrQueueFull    = errors.New("chat queue is full")
+	ErrQueueClosed  = errors.New("chat queue is closed")
+	ErrEmptyMessage = errors.New("chat message is empty")
+)
+
+// Message is a chat message to send
+type Message struct {
+	BroadcasterID        string
+	Text                 string
+	ReplyParentMessageID string
+	Priority             Priority
+	// OnResult, if not nil, is called once the message was sent or dropped,
+	// or once for every part of a message that was split
+	OnResult func(Result)
+}
+
+// key identifies identical messages, which are coalesced
+func (m Message) key() string {
+	return m.BroadcasterID + "\x00" + m.ReplyParentMessageID + "\x00" + m.Text
+}
+
+// Result reports what became of a message
+type Result struct {
+	MessageID string
+	Sent      bool
+	// DropReason is the Twitch drop code, or one of the DropReason constants
+	DropReason string
+	Attempts   int
+	Err        error
+}
+
+// QueueStats is a snapshot of the queue's counters
+type QueueStats struct {
+	Paused    bool
+	Pending   int
+	Sent      int
+	Dropped   int
+	Coalesced int
+	Retried   int
+}
+
+type item struct {
+	msg Message
+	// origin is the key of the message before it was split, and group the
+	// seq of its first part when it was split into several
+	origin    string
+	group     uint64
+	seq       uint64
+	attempts  int
+	notBefore time.Time
+	callbacks []func(Result)
+}
+
+type channelState struct {
+	pending []*item
+	sent    []time.Time
+}
+
+// Queue sends chat messages one at a time, keeping every channel within its
+// rate limit. Within a channel higher priorities go first, then older
+// messages. Identical pending messages are sent once.
+type Queue struct {
+	sender          Sender
+	senderID        string
+	limit           RateLimit
+	channelLimits   map[string]RateLimit
+	capacity        int
+	dropPolicy      DropPolicy
+	duplicateWindow time.Duration
+	maxRetries      int
+	retryDelay      time.Duration
+	maxLength       int
+	numberParts     bool
+
+	mu       sync.Mutex
+	channels map[string]*channelState
+	lastSent map[string]time.Time
+	size     int
+	seq      uint64
+	closed   bool
+	paused   bool
+	stats    QueueStats
+
+	wake   chan struct{}
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// QueueOption defines functional options for configuring the Queue
+type QueueOption func(*Queue)
+
+// WithRateLimit sets the limit of every channel without its own limit
+func WithRateLimit(limit RateLimit) QueueOption {
+	return func(q *Queue) {
+		q.limit = limit
+	}
+}
+
+// WithChannelRateLimit sets the limit of a single channel, e.g. one the bot moderates
+func WithChannelRateLimit(broadcasterID string, limit RateLimit) QueueOption {
+	return func(q *Queue) {
+		q.channelLimits[broadcasterID] = limit
+	}
+}
+
+// WithCapacity sets how many messages may wait across all channels
+func WithCapacity(capacity int) QueueOption {
+	return func(q *Queue) {
+		q.capacity = max(capacity, 1)
+	}
+}
+
+// WithDropPolicy sets what happens when a message is enqueued on a full queue
+func WithDropPolicy(policy DropPolicy) QueueOption {
+	return func(q *Queue) {
+		q.dropPolicy = policy
+	}
+}
+
+// WithDuplicateWindow drops a message identical to one sent within the
+// window, which Twitch would reject anyway
+func WithDuplicateWindow(window time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.duplicateWindow = window
+	}
+}
+
+// WithRetries sets how often a message is sent again after a transient
+// failure, and the delay before the first retry, doubled for every further one
+func WithRetries(maxRetries int, delay time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.maxRetries = maxRetries
+		q.retryDelay = delay
+	}
+}
+
+// WithMaxLength sets the length, in characters, above which a message is
+// split into several, MaxMessageLength by default
+func WithMaxLength(length int) QueueOption {
+	return func(q *Queue) {
+		q.maxLength = max(length, 1)
+	}
+}
+
+// WithNumberedParts starts every part of a split message with its position,
+// like "(1/3) "
+func WithNumberedParts(numbered bool) QueueOption {
+	return func(q *Queue) {
+		q.numberParts = numbered
+	}
+}
+
+// NewQueue creates a queue sending as senderID and starts its worker. Call
+// Close to stop it.
+func NewQueue(sender Sender, senderID string, options ...QueueOption) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &Queue{
+		sender:          sender,
+		senderID:        senderID,
+		limit:           RegularLimit,
+		channelLimits:   make(map[string]RateLimit),
+		capacity:        100,
+		dropPolicy:      DropLowestPriority,
+		duplicateWindow: 30 * time.Second,
+		maxRetries:      3,
+		retryDelay:      time.Second,
+		maxLength:       MaxMessageLength,
+		channels:        make(map[string]*channelState),
+		lastSent:        make(map[string]time.Time),
+		wake:            make(chan struct{}, 1),
+		done:            make(chan struct{}),
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+
+	for _, option := range options {
+		option(q)
+	}
+
+	go q.run()
+
+	return q
+}
+
+// Enqueue adds a message to the queue. Newlines are removed and a long
+// message is split with Split into parts that are sent in order. A message
+// identical to a pending one is merged into it, keeping the higher priority.
+// On a full queue the drop policy decides, which may reject the message with
+// ErrQueueFull.
+func (q *Queue) Enqueue(msg Message) error {
+	parts := Split(msg.Text, q.maxLength, q.numberParts)
+	if len(parts) == 0 {
+		return ErrEmptyMessage
+	}
+	origin := msg.key()
+
+	q.mu.Lock()
+
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+
+	channel := q.channel(msg.BroadcasterID)
+	coalesced := false
+	for _, pending := range channel.pending {
+		if pending.origin == origin {
+			pending.msg.Priority = max(pending.msg.Priority, msg.Priority)
+			if msg.OnResult != nil {
+				pending.callbacks = append(pending.callbacks, msg.OnResult)
+			}
+			coalesced = true
+		}
+	}
+	if coalesced {
+		q.stats.Coalesced++
+		q.mu.Unlock()
+		return nil
+	}
+
+	var evicted []*item
+	if overflow := q.size + len(parts) - q.capacity; overflow > 0 {
+		evicted = q.victims(msg.Priority, overflow)
+		if evicted == nil {
+			q.stats.Dropped++
+			q.mu.Unlock()
+			return ErrQueueFull
+		}
+		for _, victim := range evicted {
+			q.remove(victim)
+		}
+		q.stats.Dropped += len(evicted)
+	}
+
+	var group uint64
+	for i, part := range parts {
+		q.seq++
+		if len(parts) > 1 && i == 0 {
+			group = q.seq
+		}
+
+		partMsg := msg
+		partMsg.Text = part
+		newItem := &item{msg: partMsg, origin: origin, group: group, seq: q.seq}
+		if msg.OnResult != nil {
+			newItem.callbacks = append(newItem.callbacks, msg.OnResult)
+		}
+		channel.pending = append(channel.pending, newItem)
+		q.size++
+	}
+	q.mu.Unlock()
+
+	for _, victim := range evicted {
+		logger.Warn("chat queue full, dropped message",
+			zap.String("channel", victim.msg.BroadcasterID),
+			zap.String("message", victim.msg.Text),
+		)
+		finish(victim, Result{DropReason: DropReasonEvicted, Attempts: victim.attempts})
+	}
+
+	q.signal()
+	return nil
+}
+
+// Stats returns a snapshot of the queue's counters
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Pending = q.size
+	stats.Paused = q.paused
+	return stats
+}
+
+// Pause holds every message in the queue until Resume, e.g. while the bot's
+// token is invalid. Messages can still be enqueued.
+func (q *Queue) Pause() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.paused {
+		logger.Warn("chat queue paused", zap.Int("pending", q.size))
+	}
+	q.paused = true
+}
+
+// Resume sends the messages held by Pause
+func (q *Queue) Resume() {
+	q.mu.Lock()
+	if q.paused {
+		logger.Info("chat queue resumed", zap.Int("pending", q.size))
+	}
+	q.paused = false
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// Close stops accepting messages and waits until the pending ones are sent.
+// When ctx is done first, the rest is dropped with DropReasonClosed.
+func (q *Queue) Close(ctx context.Context) {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		q.cancel()
+		<-q.done
+	}
+	q.cancel()
+}
+
+// run is the worker sending one message at a time
+func (q *Queue) run() {
+	defer close(q.done)
+
+	for {
+		q.mu.Lock()
+		next, wait := q.next(time.Now())
+		if next != nil {
+			q.remove(next)
+		}
+		finished := next == nil && q.closed && q.size == 0
+		q.mu.Unlock()
+
+		if finished {
+			return
+		}
+
+		if next != nil {
+			q.send(next)
+			continue
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-q.wake:
+		case <-timeout:
+		case <-q.ctx.Done():
+			q.dropAll()
+			return
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// next picks the message to send now: the highest priority, then oldest,
+// message of a channel within its rate limit. Parts of a split message wait
+// for the earlier parts, including their retries. Otherwise it returns how long
+// to wait until one may be sent, or 0 to wait for a new message. Callers
+// must hold q.mu.
+func (q *Queue) next(now time.Time) (*item, time.Duration) {
+	var best *item
+	var wait time.Duration
+
+	if q.paused {
+		return nil, 0
+	}
+
+	later := func(at time.Time) {
+		if d := at.Sub(now); wait == 0 || d < wait {
+			wait = max(d, time.Millisecond)
+		}
+	}
+
+	for broadcasterID, channel := range q.channels {
+		if len(channel.pending) == 0 {
+			continue
+		}
+
+		limit := q.limitOf(broadcasterID)
+		for len(channel.sent) > 0 && now.Sub(channel.sent[0]) >= limit.Per {
+			channel.sent = channel.sent[1:]
+		}
+		if len(channel.sent) >= limit.Messages {
+			later(channel.sent[0].Add(limit.Per))
+			continue
+		}
+
+		firstOfGroup := make(map[uint64]uint64)
+		for _, pending := range channel.pending {
+			if first, ok := firstOfGroup[pending.group]; pending.group != 0 && (!ok || pending.seq < first) {
+				firstOfGroup[pending.group] = pending.seq
+			}
+		}
+
+		for _, candidate := range channel.pending {
+			if candidate.group != 0 && firstOfGroup[candidate.group] != candidate.seq {
+				continue
+			}
+			if candidate.notBefore.After(now) {
+				later(candidate.notBefore)
+				continue
+			}
+			if best == nil ||
+				candidate.msg.Priority > best.msg.Priority ||
+				(candidate.msg.Priority == best.msg.Priority && candidate.seq < best.seq) {
+				best = candidate
+			}
+		}
+	}
+
+	return best, wait
+}
+
+// send delivers a message and retries or reports the outcome
+func (q *Queue) send(it *item) {
+	now := time.Now()
+	key := it.msg.key()
+
+	q.mu.Lock()
+	for k, at := range q.lastSent {
+		if now.Sub(at) >= q.duplicateWindow {
+			delete(q.lastSent, k)
+		}
+	}
+	if _, duplicate := q.lastSent[key]; duplicate {
+		q.stats.Dropped++
+		q.mu.Unlock()
+		finish(it, Result{DropReason: DropReasonDuplicate, Attempts: it.attempts})
+		return
+	}
+	channel := q.channel(it.msg.BroadcasterID)
+	channel.sent = append(channel.sent, now)
+	q.mu.Unlock()
+
+	it.attempts++
+	result, err := q.sender.SendChatMessage(q.ctx, helix.ChatMessageRequest{
+		BroadcasterID:        it.msg.BroadcasterID,
+		SenderID:             q.senderID,
+		Message:              it.msg.Text,
+		ReplyParentMessageID: it.msg.ReplyParentMessageID,
+	})
+
+	if err != nil {
+		if isTransient(err) && q.retry(it) {
+			logger.Warn("failed to send chat message, retrying", zap.Int("attempt", it.attempts), zap.Error(err))
+			return
+		}
+		logger.Error("failed to send chat message", zap.String("message", it.msg.Text), zap.Error(err))
+		q.countDropped()
+		finish(it, Result{Attempts: it.attempts, Err: err})
+		return
+	}
+
+	if !result.IsSent {
+		reason := "unknown"
+		if result.DropReason != nil {
+			reason = result.DropReason.Code
+		}
+		if retryableDropCodes[reason] && q.retry(it) {
+			logger.Warn("chat message dropped by Twitch, retrying", zap.String("reason", reason), zap.Int("attempt", it.attempts))
+			return
+		}
+		logger.Warn("chat message dropped by Twitch", zap.String("message", it.msg.Text), zap.String("reason", reason))
+		q.countDropped()
+		finish(it, Result{MessageID: result.MessageID, DropReason: reason, Attempts: it.attempts})
+		return
+	}
+
+	q.mu.Lock()
+	q.lastSent[key] = time.Now()
+	q.stats.Sent++
+	q.mu.Unlock()
+
+	logger.Info("chat message sent", zap.String("message", it.msg.Text))
+	finish(it, Result{MessageID: result.MessageID, Sent: true, Attempts: it.attempts})
+}
+
+// retry puts a message back with a delay if it has attempts left
+func (q *Queue) retry(it *item) bool {
+	if it.attempts > q.maxRetries || q.ctx.Err() != nil {
+		return false
+	}
+
+	it.notBefore = time.Now().Add(q.retryDelay << (it.attempts - 1))
+
+	q.mu.Lock()
+	channel := q.channel(it.msg.BroadcasterID)
+	channel.pending = append(channel.pending, it)
+	q.size++
+	q.stats.Retried++
+	q.mu.Unlock()
+
+	q.signal()
+	return true
+}
+
+// isTransient reports whether a send error may go away on its own: network
+// errors, rate limits and server errors
+func isTransient(err error) bool {
+	var apiErr *helix.APIError
+	if !errors.As(err, &apiErr) {
+		return !errors.Is(err, context.Canceled)
+	}
+	return apiErr.StatusCode == 429 || apiErr.StatusCode >= 500
+}
+
+// victims picks count messages to drop for a new one of the given priority,
+// or nil to reject the new one. Callers must hold q.mu.
+func (q *Queue) victims(priority Priority, count int) []*item {
+	if q.dropPolicy == DropNewest {
+		return nil
+	}
+
+	picked := make(map[*item]bool, count)
+	var victims []*item
+	for range count {
+		var victim *item
+		for _, channel := range q.channels {
+			for _, candidate := range channel.pending {
+				if picked[candidate] {
+					continue
+				}
+				switch q.dropPolicy {
+				case DropOldest:
+					if victim == nil || candidate.seq < victim.seq {
+						victim = candidate
+					}
+				case DropLowestPriority:
+					if victim == nil ||
+						candidate.msg.Priority < victim.msg.Priority ||
+						(candidate.msg.Priority == victim.msg.Priority && candidate.seq > victim.seq) {
+						victim = candidate
+					}
+				}
+			}
+		}
+
+		if victim == nil || (q.dropPolicy == DropLowestPriority && victim.msg.Priority >= priority) {
+			return nil
+		}
+		picked[victim] = true
+		victims = append(victims, victim)
+	}
+	return victims
+}
+
+// remove takes a message out of its channel. Callers must hold q.mu.
+func (q *Queue) remove(it *item) {
+	channel := q.channel(it.msg.BroadcasterID)
+	for i, pending := range channel.pending {
+		if pending == it {
+			channel.pending = append(channel.pending[:i:i], channel.pending[i+1:]...)
+			q.size--
+			return
+		}
+	}
+}
+
+// channel returns the state of a channel, creating it. Callers must hold q.mu.
+func (q *Queue) channel(broadcasterID string) *channelState {
+	channel, ok := q.channels[broadcasterID]
+	if !ok {
+		channel = &channelState{}
+		q.channels[broadcasterID] = channel
+	}
+	return channel
+}
+
+func (q *Queue) limitOf(broadcasterID string) RateLimit {
+	if limit, ok := q.channelLimits[broadcasterID]; ok {
+		return limit
+	}
+	return q.limit
+}
+
+func (q *Queue) countDropped() {
+	q.mu.Lock()
+	q.stats.Dropped++
+	q.mu.Unlock()
+}
+
+// dropAll reports every pending message as dropped when the queue is closed
+func (q *Queue) dropAll() {
+	q.mu.Lock()
+	var dropped []*item
+	for _, channel := range q.channels {
+		dropped = append(dropped, channel.pending...)
+		channel.pending = nil
+	}
+	q.size = 0
+	q.stats.Dropped += len(dropped)
+	q.mu.Unlock()
+
+	for _, it := range dropped {
+		finish(it, Result{DropReason: DropReasonClosed, Attempts: it.attempts})
+	}
+}
+
+// signal wakes the worker up without blocking
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// finish reports the result to everyone waiting for the message
+func finish(it *item, result Result) {
+	for _, callback := range it.callbacks {
+		callback(result)
+	}
+}
@@ -0,0 +1,199 @@
+package chat_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/chat"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+)
+
+// fakeSender records sent messages and fails with the queued errors first
+type fakeSender struct {
+	mu     sync.Mutex
+	sent   []string
+	errors []error
+}
+
+func (s *fakeSender) SendChatMessage(ctx context.Context, request helix.ChatMessageRequest) (*helix.ChatMessageResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errors) > 0 {
+		err := s.errors[0]
+		s.errors = s.errors[1:]
+		return nil, err
+	}
+	s.sent = append(s.sent, request.Message)
+	return &helix.ChatMessageResult{MessageID: request.Message, IsSent: true}, nil
+}
+
+func (s *fakeSender) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.sent)
+}
+
+// results collects the results of messages by text
+type results struct {
+	mu     sync.Mutex
+	byText map[string][]chat.Result
+}
+
+func (r *results) of(text string) func(chat.Result) {
+	return func(result chat.Result) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.byText == nil {
+			r.byText = make(map[string][]chat.Result)
+		}
+		r.byText[text] = append(r.byText[text], result)
+	}
+}
+
+func (r *results) get(text string) []chat.Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byText[text]
+}
+
+func closeQueue(q *chat.Queue) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	q.Close(ctx)
+}
+
+func TestQueueSendsHigherPriorityFirst(t *testing.T) {
+	sender := &fakeSender{}
+	q := chat.NewQueue(sender, "bot")
+	q.Pause()
+
+	for _, msg := range []chat.Message{
+		{BroadcasterID: "1", Text: "low", Priority: chat.PriorityLow},
+		{BroadcasterID: "1", Text: "normal", Priority: chat.PriorityNormal},
+		{BroadcasterID: "1", Text: "high", Priority: chat.PriorityHigh},
+		{BroadcasterID: "1", Text: "normal again", Priority: chat.PriorityNormal},
+	} {
+		if err := q.Enqueue(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Resume()
+	closeQueue(q)
+
+	if got, want := sender.messages(), []string{"high", "normal", "normal again", "low"}; !slices.Equal(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
+
+func TestQueueCoalescesIdenticalMessages(t *testing.T) {
+	sender := &fakeSender{}
+	var r results
+	q := chat.NewQueue(sender, "bot")
+	q.Pause()
+
+	q.Enqueue(chat.Message{BroadcasterID: "1", Text: "hi", Priority: chat.PriorityLow, OnResult: r.of("hi")})
+	q.Enqueue(chat.Message{BroadcasterID: "1", Text: "other"})
+	// The merged message keeps the higher priority, so it now goes first
+	q.Enqueue(chat.Message{BroadcasterID: "1", Text: "hi", Priority: chat.PriorityHigh, OnResult: r.of("hi")})
+	q.Resume()
+	closeQueue(q)
+
+	if got, want := sender.messages(), []string{"hi", "other"}; !slices.Equal(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+	if got := r.get("hi"); len(got) != 2 || !got[0].Sent || !got[1].Sent {
+		t.Errorf("results %+v, want both callers told it was sent", got)
+	}
+	if stats := q.Stats(); stats.Coalesced != 1 || stats.Sent != 2 {
+		t.Errorf("stats %+v, want 1 coalesced and 2 sent", stats)
+	}
+}
+
+func TestQueueEvictsLowestPriority(t *testing.T) {
+	sender := &fakeSender{}
+	var r results
+	q := chat.NewQueue(sender, "bot", chat.WithCapacity(2), chat.WithDropPolicy(chat.DropLowestPriority))
+	q.Pause()
+
+	q.Enqueue(chat.Message{BroadcasterID: "1", Text: "low", Priority: chat.PriorityLow, OnResult: r.of("low")})
+	q.Enqueue(chat.Message{BroadcasterID: "2", Text: "normal"})
+	if err := q.Enqueue(chat.Message{BroadcasterID: "1", Text: "high", Priority: chat.PriorityHigh}); err != nil {
+		t.Fatalf("high priority on a full queue: %v", err)
+	}
+	if err := q.Enqueue(chat.Message{BroadcasterID: "1", Text: "another low", Priority: chat.PriorityLow}); !errors.Is(err, chat.ErrQueueFull) {
+		t.Errorf("low priority on a full queue: got %v, want ErrQueueFull", err)
+	}
+
+	if got := r.get("low"); len(got) != 1 || got[0].DropReason != chat.DropReasonEvicted {
+		t.Errorf("evicted message results %+v", got)
+	}
+
+	q.Resume()
+	closeQueue(q)
+
+	sent := sender.messages()
+	slices.Sort(sent)
+	if want := []string{"high", "normal"}; !slices.Equal(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+}
+
+func TestQueueKeepsChannelRateLimit(t *testing.T) {
+	sender := &fakeSender{}
+	var r results
+	q := chat.NewQueue(sender, "bot",
+		chat.WithRateLimit(chat.RateLimit{Messages: 1, Per: time.Hour}),
+		chat.WithChannelRateLimit("2", chat.ModeratorLimit),
+	)
+
+	q.Enqueue(chat.Message{BroadcasterID: "1", Text: "first"})
+	q.Enqueue(chat.Message{BroadcasterID: "1", Text: "over the limit", OnResult: r.of("over the limit")})
+	q.Enqueue(chat.Message{BroadcasterID: "2", Text: "moderated channel"})
+	q.Enqueue(chat.Message{BroadcasterID: "2", Text: "still within its limit"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	q.Close(ctx)
+
+	sent := sender.messages()
+	slices.Sort(sent)
+	if want := []string{"first", "moderated channel", "still within its limit"}; !slices.Equal(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+	if got := r.get("over the limit"); len(got) != 1 || got[0].DropReason != chat.DropReasonClosed {
+		t.Errorf("results %+v, want dropped on close", got)
+	}
+}
+
+func TestQueueRetriesTransientErrors(t *testing.T) {
+	sender := &fakeSender{errors: []error{&helix.APIError{StatusCode: 503}}}
+	var r results
+	q := chat.NewQueue(sender, "bot", chat.WithRetries(2, time.Millisecond))
+
+	q.Enqueue(chat.Message{BroadcasterID: "1", Text: "hello", OnResult: r.of("hello")})
+	closeQueue(q)
+
+	if got := r.get("hello"); len(got) != 1 || !got[0].Sent || got[0].Attempts != 2 {
+		t.Errorf("results %+v, want sent on the second attempt", got)
+	}
+	if stats := q.Stats(); stats.Retried != 1 {
+		t.Errorf("stats %+v, want 1 retry", stats)
+	}
+}
+
+func TestQueueDoesNotRetryClientErrors(t *testing.T) {
+	sender := &fakeSender{errors: []error{&helix.APIError{StatusCode: 403}}}
+	var r results
+	q := chat.NewQueue(sender, "bot", chat.WithRetries(2, time.Millisecond))
+
+	q.Enqueue(chat.Message{BroadcasterID: "1", Text: "hello", OnResult: r.of("hello")})
+	closeQueue(q)
+
+	if got := r.get("hello"); len(got) != 1 || got[0].Sent || got[0].Err == nil || got[0].Attempts != 1 {
+		t.Errorf("results %+v, want one failed attempt", got)
+	}
+}
@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxMessageLength is the most characters Twitch accepts in a chat message
+const MaxMessageLength = 500
+
+// Clean collapses newlines, tabs and repeated spaces into single spaces,
+// which is how a chat message must look to be accepted
+func Clean(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Split cleans a message and splits it into parts of at most limit
+// characters. Parts end at sentence boundaries where possible, otherwise at
+// word boundaries, and only a word longer than a whole part is cut. Lengths
+// are counted in runes, so Cyrillic and other multi-byte text is never cut
+// within a character. With numbered, every part of a split message starts
+// with its position, like "(1/3) ".
+func Split(text string, limit int, numbered bool) []string {
+	text = Clean(text)
+	if text == "" {
+		return nil
+	}
+	if utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+	if !numbered {
+		return splitRunes([]rune(text), limit)
+	}
+
+	// The prefix grows with the number of parts, so retry with room for one
+	// more digit until the parts fit the prefix width
+	for digits, bound := 1, 10; ; digits, bound = digits+1, bound*10 {
+		parts := splitRunes([]rune(text), max(limit-prefixLength(digits), 1))
+		if len(parts) < bound {
+			for i, part := range parts {
+				parts[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(parts), part)
+			}
+			return parts
+		}
+	}
+}
+
+// prefixLength is the longest "(i/n) " prefix when n has the given digits
+func prefixLength(digits int) int {
+	return 2*digits + 4
+}
+
+// splitRunes cuts text into parts of at most limit runes
+func splitRunes(text []rune, limit int) []string {
+	var parts []string
+	for len(text) > limit {
+		cut := cutIndex(text, limit)
+		parts = append(parts, strings.TrimSpace(string(text[:cut])))
+		text = []rune(strings.TrimLeftFunc(string(text[cut:]), unicode.IsSpace))
+	}
+	if len(text) > 0 {
+		parts = append(parts, string(text))
+	}
+	return parts
+}
+
+// cutIndex finds where to end a part of text, which is longer than limit:
+// after the last sentence that fits, unless that leaves the part less than a
+// third full, then at the last space, or else at limit
+func cutIndex(text []rune, limit int) int {
+	lastSpace := -1
+	for i := limit; i > 0; i-- {
+		if !unicode.IsSpace(text[i]) {
+			continue
+		}
+		if lastSpace < 0 {
+			lastSpace = i
+		}
+		if i < limit/3 {
+			break
+		}
+		if isSentenceEnd(text[i-1]) {
+			return i
+		}
+	}
+	if lastSpace > 0 {
+		return lastSpace
+	}
+	return limit
+}
+
+func isSentenceEnd(r rune) bool {
+	switch r {
+	case '.', '!', '?', '…':
+		return true
+	}
+	return false
+}
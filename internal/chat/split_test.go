@@ -0,0 +1,97 @@
+package chat_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/chat"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"short", "hello chat", 500, []string{"hello chat"}},
+		{"cleaned", "hello\n\n  chat\tagain", 500, []string{"hello chat again"}},
+		{"empty", " \n\t", 500, nil},
+		{"sentences", "First sentence here. Second one is longer text.", 30, []string{"First sentence here.", "Second one is longer text."}},
+		{"words", "aaaa bbbb cccc", 7, []string{"aaaa", "bbbb", "cccc"}},
+		{"long word", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"cyrillic", "привіт світ", 8, []string{"привіт", "світ"}},
+		// A sentence end too early in the part would waste most of it
+		{"early sentence end", "Ok. this part goes on without a stop", 30, []string{"Ok. this part goes on without", "a stop"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chat.Split(tt.text, tt.limit, false); !slices.Equal(got, tt.want) {
+				t.Errorf("Split(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCountsRunes(t *testing.T) {
+	text := strings.Repeat("ї", 1200)
+
+	parts := chat.Split(text, chat.MaxMessageLength, false)
+	if len(parts) != 3 {
+		t.Fatalf("got %d parts, want 3", len(parts))
+	}
+	for _, part := range parts {
+		if !utf8.ValidString(part) || utf8.RuneCountInString(part) > chat.MaxMessageLength {
+			t.Errorf("part of %d runes, valid %v", utf8.RuneCountInString(part), utf8.ValidString(part))
+		}
+	}
+	if strings.Join(parts, "") != text {
+		t.Error("parts do not add up to the text")
+	}
+}
+
+func TestSplitNumbered(t *testing.T) {
+	text := strings.Repeat("word ", 30)
+
+	parts := chat.Split(text, 40, true)
+	for i, part := range parts {
+		if utf8.RuneCountInString(part) > 40 {
+			t.Errorf("part %q is longer than the limit", part)
+		}
+		if prefix := fmt.Sprintf("(%d/%d) ", i+1, len(parts)); !strings.HasPrefix(part, prefix) {
+			t.Errorf("part %q does not start with %q", part, prefix)
+		}
+	}
+
+	var words []string
+	for _, part := range parts {
+		words = append(words, strings.Fields(part)[1:]...)
+	}
+	if strings.Join(words, " ") != strings.TrimSpace(text) {
+		t.Error("parts do not add up to the text")
+	}
+
+	// A message that fits is not numbered
+	if got := chat.Split("short", 40, true); !slices.Equal(got, []string{"short"}) {
+		t.Errorf("got %q, want it unchanged", got)
+	}
+}
+
+func TestSplitNumberedWidensPrefix(t *testing.T) {
+	parts := chat.Split(strings.Repeat("ab ", 60), 10, true)
+	if len(parts) < 10 {
+		t.Fatalf("got %d parts, want at least 10", len(parts))
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) > 10 {
+			t.Errorf("part %q is longer than the limit", part)
+		}
+	}
+	if last := parts[len(parts)-1]; !strings.HasPrefix(last, "(") || !strings.Contains(last, "/") {
+		t.Errorf("last part %q is not numbered", last)
+	}
+}
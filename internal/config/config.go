@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -15,12 +16,45 @@ type Config struct {
 	LogLevel             string
 	Environment          string
 	BotUserId            string
+	BotLogin             string
 	OauthToken           string
 	ClientId             string
 	ClientSecret         string
 	ChatChannelUserId    string
 	EventsubWebsocketUrl string
 	TwitchSecretState    string
+	// OauthRedirectUrl is where Twitch sends the authorization code, the
+	// server's /twitch/callback
+	OauthRedirectUrl string
+	// TokenStoreFile keeps the OAuth tokens between runs, encrypted with
+	// TokenStoreKey, a base64 encoded 32 byte key. Without a key tokens are
+	// not kept.
+	TokenStoreFile string
+	TokenStoreKey  string
+	// HelixBaseUrl and IdBaseUrl point the bot at the Twitch API and the
+	// Twitch identity service, or at a fake of them
+	HelixBaseUrl string
+	IdBaseUrl    string
+	// EventsubSubscriptions lists the EventSub types to subscribe to, as
+	// "type" or "type@version" entries
+	EventsubSubscriptions []string
+	// EventsubTransport selects how notifications are delivered: "websocket",
+	// "webhook" or "conduit"
+	EventsubTransport  string
+	WebhookCallbackUrl string
+	WebhookSecret      string
+	// ConduitId reuses an existing conduit; a new one is created when empty
+	ConduitId     string
+	ConduitShards int
+	// EventsubRecordFile, when set, is a JSONL file every received EventSub
+	// frame is appended to, for replaying later
+	EventsubRecordFile string
+	// ChatBotRole is the bot's role in the chat channel, which sets its chat
+	// rate limit: "regular", "moderator", "broadcaster" or "verified"
+	ChatBotRole string
+	// ModeratorUserId is an optional moderator account with its own token;
+	// the bot moderates when it is empty
+	ModeratorUserId string
 }
 
 // Load returns app configuration from .env file and environment variables
@@ -36,18 +70,39 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	conduitShards, err := strconv.Atoi(getEnv("CONDUIT_SHARDS", "1"))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		// Server configs
 		ServerPort:           port,
 		LogLevel:             getEnv("LOG_LEVEL", "info"),
 		Environment:          getEnv("APP_ENV", "development"),
 		BotUserId:            getEnv("BOT_USER_ID", "undefined"),
+		BotLogin:             getEnv("BOT_LOGIN", ""),
 		OauthToken:           getEnv("OAUTH_TOKEN", "undefined"),
 		ClientId:             getEnv("CLIENT_ID", "undefined"),
 		ClientSecret:         getEnv("CLIENT_SECRET", "undefined"),
 		ChatChannelUserId:    getEnv("CHAT_CHANNEL_USER_ID", "undefined"),
+		ModeratorUserId:      getEnv("MODERATOR_USER_ID", ""),
 		EventsubWebsocketUrl: getEnv("EVENTSUB_WEBSOCKET_URL", "undefined"),
 		TwitchSecretState:    getEnv("TWITCH_SECRET_STATE", "undefined"),
+		OauthRedirectUrl:     getEnv("OAUTH_REDIRECT_URL", "http://localhost:3000/twitch/callback"),
+		TokenStoreFile:       getEnv("TOKEN_STORE_FILE", "tokens.enc"),
+		TokenStoreKey:        getEnv("TOKEN_STORE_KEY", ""),
+		HelixBaseUrl:         getEnv("HELIX_BASE_URL", "https://api.twitch.tv/helix"),
+		IdBaseUrl:            getEnv("ID_BASE_URL", "https://id.twitch.tv"),
+
+		EventsubSubscriptions: getEnvList("EVENTSUB_SUBSCRIPTIONS", "channel.chat.message"),
+		EventsubTransport:     getEnv("EVENTSUB_TRANSPORT", "websocket"),
+		WebhookCallbackUrl:    getEnv("WEBHOOK_CALLBACK_URL", ""),
+		WebhookSecret:         getEnv("WEBHOOK_SECRET", ""),
+		ConduitId:             getEnv("CONDUIT_ID", ""),
+		ConduitShards:         conduitShards,
+		EventsubRecordFile:    getEnv("EVENTSUB_RECORD_FILE", ""),
+		ChatBotRole:           getEnv("CHAT_BOT_ROLE", "regular"),
 	}, nil
 }
 
@@ -57,3 +112,13 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvList(key, fallback string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
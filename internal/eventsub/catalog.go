@@ -0,0 +1,112 @@
+package eventsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownSubscriptionType is returned for subscription types missing from the catalog
+var ErrUnknownSubscriptionType = errors.New("unknown subscription type")
+
+// ConditionIDs are the user ids subscription conditions are built from
+type ConditionIDs struct {
+	BroadcasterUserID string // the channel the bot works in
+	UserID            string // the bot account
+	ModeratorUserID   string // defaults to BroadcasterUserID
+}
+
+type catalogEntry struct {
+	version   string
+	condition func(ids ConditionIDs) map[string]string
+}
+
+func broadcasterCondition(ids ConditionIDs) map[string]string {
+	return map[string]string{"broadcaster_user_id": ids.BroadcasterUserID}
+}
+
+func moderatorCondition(ids ConditionIDs) map[string]string {
+	moderator := ids.ModeratorUserID
+	if moderator == "" {
+		moderator = ids.BroadcasterUserID
+	}
+	return map[string]string{
+		"broadcaster_user_id": ids.BroadcasterUserID,
+		"moderator_user_id":   moderator,
+	}
+}
+
+// catalog lists the subscription types that can be enabled by name, with
+// their default version and how to build their condition
+var catalog = map[string]catalogEntry{
+	SubscriptionChannelChatMessage: {"1", func(ids ConditionIDs) map[string]string {
+		return map[string]string{
+			"broadcaster_user_id": ids.BroadcasterUserID,
+			"user_id":             ids.UserID,
+		}
+	}},
+	SubscriptionChannelFollow:              {"2", moderatorCondition},
+	SubscriptionChannelSubscribe:           {"1", broadcasterCondition},
+	SubscriptionChannelSubscriptionGift:    {"1", broadcasterCondition},
+	SubscriptionChannelSubscriptionMessage: {"1", broadcasterCondition},
+	SubscriptionChannelCheer:               {"1", broadcasterCondition},
+	SubscriptionChannelRaid: {"1", func(ids ConditionIDs) map[string]string {
+		return map[string]string{"to_broadcaster_user_id": ids.BroadcasterUserID}
+	}},
+	SubscriptionChannelPointsRedemptionAdd: {"1", broadcasterCondition},
+	SubscriptionStreamOnline:               {"1", broadcasterCondition},
+	SubscriptionStreamOffline:              {"1", broadcasterCondition},
+	SubscriptionChannelPollBegin:           {"1", broadcasterCondition},
+	SubscriptionChannelPollProgress:        {"1", broadcasterCondition},
+	SubscriptionChannelPollEnd:             {"1", broadcasterCondition},
+	SubscriptionChannelPredictionBegin:     {"1", broadcasterCondition},
+	SubscriptionChannelPredictionProgress:  {"1", broadcasterCondition},
+	SubscriptionChannelPredictionLock:      {"1", broadcasterCondition},
+	SubscriptionChannelPredictionEnd:       {"1", broadcasterCondition},
+	SubscriptionChannelHypeTrainBegin:      {"1", broadcasterCondition},
+	SubscriptionChannelHypeTrainProgress:   {"1", broadcasterCondition},
+	SubscriptionChannelHypeTrainEnd:        {"1", broadcasterCondition},
+}
+
+// NewSubscriptionRequest builds the request for a catalog subscription type.
+// An empty version selects the catalog default.
+func NewSubscriptionRequest(subscriptionType, version string, ids ConditionIDs) (SubscriptionRequest, error) {
+	entry, ok := catalog[subscriptionType]
+	if !ok {
+		return SubscriptionRequest{}, fmt.Errorf("%w: %q", ErrUnknownSubscriptionType, subscriptionType)
+	}
+
+	if version == "" {
+		version = entry.version
+	}
+
+	return SubscriptionRequest{
+		Type:      subscriptionType,
+		Version:   version,
+		Condition: entry.condition(ids),
+	}, nil
+}
+
+// ParseSubscriptionList builds requests from configuration entries of the form
+// "type" or "type@version", e.g. "channel.follow@2". Invalid entries are
+// skipped and reported together in the error, the valid ones are still returned.
+func ParseSubscriptionList(entries []string, ids ConditionIDs) ([]SubscriptionRequest, error) {
+	requests := make([]SubscriptionRequest, 0, len(entries))
+	var errs []error
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		subscriptionType, version, _ := strings.Cut(entry, "@")
+		request, err := NewSubscriptionRequest(subscriptionType, version, ids)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		requests = append(requests, request)
+	}
+
+	return requests, errors.Join(errs...)
+}
@@ -0,0 +1,28 @@
+package eventsub_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+func TestParseSubscriptionListSkipsInvalidEntries(t *testing.T) {
+	ids := eventsub.ConditionIDs{BroadcasterUserID: "2", UserID: "1"}
+
+	requests, err := eventsub.ParseSubscriptionList([]string{"channel.chat.message", " channel.folow ", "channel.follow@2", ""}, ids)
+	if !errors.Is(err, eventsub.ErrUnknownSubscriptionType) {
+		t.Errorf("got error %v, want ErrUnknownSubscriptionType", err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want the 2 valid ones", len(requests))
+	}
+	if r := requests[0]; r.Type != eventsub.SubscriptionChannelChatMessage || r.Version != "1" || r.Condition["user_id"] != "1" {
+		t.Errorf("chat request %+v", r)
+	}
+	// The moderator defaults to the broadcaster
+	if r := requests[1]; r.Type != eventsub.SubscriptionChannelFollow || r.Version != "2" || r.Condition["moderator_user_id"] != "2" {
+		t.Errorf("follow request %+v", r)
+	}
+}
@@ -0,0 +1,125 @@
+package eventsub
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrDuplicateMessage is returned for a message id that was already seen
+	ErrDuplicateMessage = errors.New("duplicate eventsub message")
+	// ErrStaleMessage is returned for a message older than the accepted window
+	ErrStaleMessage = errors.New("stale eventsub message")
+)
+
+// Twitch recommends rejecting messages older than ten minutes and remembering
+// message ids for at least as long
+const (
+	DefaultDedupCapacity = 10000
+	DefaultDedupWindow   = 10 * time.Minute
+)
+
+// DedupStats counts the messages a Deduplicator rejected
+type DedupStats struct {
+	Duplicates uint64
+	Stale      uint64
+}
+
+type seenMessage struct {
+	id     string
+	seenAt time.Time
+}
+
+// Deduplicator rejects redelivered and stale messages. Seen ids are kept in an
+// LRU bounded both by capacity and by age.
+type Deduplicator struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	maxAge   time.Duration
+	seen     map[string]*list.Element
+	order    *list.List // newest first, so expiry only looks at the back
+	now      func() time.Time
+
+	duplicates atomic.Uint64
+	stale      atomic.Uint64
+}
+
+// NewDeduplicator creates a Deduplicator that remembers up to capacity ids for
+// ttl and rejects messages whose timestamp is older than maxAge. A zero maxAge
+// disables the age check.
+func NewDeduplicator(capacity int, ttl, maxAge time.Duration) *Deduplicator {
+	if capacity < 1 {
+		capacity = DefaultDedupCapacity
+	}
+
+	return &Deduplicator{
+		capacity: capacity,
+		ttl:      ttl,
+		maxAge:   maxAge,
+		seen:     make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Check records the message as seen and returns ErrDuplicateMessage or
+// ErrStaleMessage when it must not be processed
+func (d *Deduplicator) Check(metadata Metadata) error {
+	now := d.now()
+
+	if d.maxAge > 0 && !metadata.MessageTimestamp.IsZero() && now.Sub(metadata.MessageTimestamp) > d.maxAge {
+		d.stale.Add(1)
+		return ErrStaleMessage
+	}
+
+	if metadata.MessageID == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire(now)
+
+	if _, ok := d.seen[metadata.MessageID]; ok {
+		d.duplicates.Add(1)
+		return ErrDuplicateMessage
+	}
+
+	d.seen[metadata.MessageID] = d.order.PushFront(&seenMessage{id: metadata.MessageID, seenAt: now})
+	for d.order.Len() > d.capacity {
+		d.remove(d.order.Back())
+	}
+
+	return nil
+}
+
+// Stats returns how many messages were rejected so far
+func (d *Deduplicator) Stats() DedupStats {
+	return DedupStats{
+		Duplicates: d.duplicates.Load(),
+		Stale:      d.stale.Load(),
+	}
+}
+
+// expire drops ids older than the ttl. Callers must hold d.mu.
+func (d *Deduplicator) expire(now time.Time) {
+	if d.ttl <= 0 {
+		return
+	}
+	for element := d.order.Back(); element != nil; element = d.order.Back() {
+		if now.Sub(element.Value.(*seenMessage).seenAt) <= d.ttl {
+			return
+		}
+		d.remove(element)
+	}
+}
+
+func (d *Deduplicator) remove(element *list.Element) {
+	d.order.Remove(element)
+	delete(d.seen, element.Value.(*seenMessage).id)
+}
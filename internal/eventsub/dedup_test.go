@@ -0,0 +1,89 @@
+package eventsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a Deduplicator clock that only moves when advanced
+func fakeClock(d *Deduplicator) func(time.Duration) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	return func(elapsed time.Duration) { now = now.Add(elapsed) }
+}
+
+func TestDeduplicatorRejectsRedelivery(t *testing.T) {
+	d := NewDeduplicator(10, time.Minute, 0)
+
+	if err := d.Check(Metadata{MessageID: "a"}); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+	if err := d.Check(Metadata{MessageID: "a"}); !errors.Is(err, ErrDuplicateMessage) {
+		t.Errorf("redelivery: got %v, want ErrDuplicateMessage", err)
+	}
+	if err := d.Check(Metadata{MessageID: "b"}); err != nil {
+		t.Errorf("other message: %v", err)
+	}
+	// Messages without an id cannot be told apart
+	for range 2 {
+		if err := d.Check(Metadata{}); err != nil {
+			t.Errorf("message without id: %v", err)
+		}
+	}
+
+	if stats := d.Stats(); stats.Duplicates != 1 || stats.Stale != 0 {
+		t.Errorf("stats %+v, want 1 duplicate", stats)
+	}
+}
+
+func TestDeduplicatorRejectsStaleMessages(t *testing.T) {
+	d := NewDeduplicator(10, time.Minute, 10*time.Minute)
+	fakeClock(d)
+	now := d.now()
+
+	if err := d.Check(Metadata{MessageID: "old", MessageTimestamp: now.Add(-11 * time.Minute)}); !errors.Is(err, ErrStaleMessage) {
+		t.Errorf("old message: got %v, want ErrStaleMessage", err)
+	}
+	if err := d.Check(Metadata{MessageID: "recent", MessageTimestamp: now.Add(-9 * time.Minute)}); err != nil {
+		t.Errorf("recent message: %v", err)
+	}
+	if err := d.Check(Metadata{MessageID: "untimed"}); err != nil {
+		t.Errorf("message without timestamp: %v", err)
+	}
+
+	if stats := d.Stats(); stats.Stale != 1 {
+		t.Errorf("stats %+v, want 1 stale", stats)
+	}
+}
+
+func TestDeduplicatorForgetsAfterTTL(t *testing.T) {
+	d := NewDeduplicator(10, time.Minute, 0)
+	advance := fakeClock(d)
+
+	d.Check(Metadata{MessageID: "a"})
+	advance(30 * time.Second)
+	if err := d.Check(Metadata{MessageID: "a"}); !errors.Is(err, ErrDuplicateMessage) {
+		t.Errorf("within ttl: got %v, want ErrDuplicateMessage", err)
+	}
+
+	advance(31 * time.Second)
+	if err := d.Check(Metadata{MessageID: "a"}); err != nil {
+		t.Errorf("after ttl: %v", err)
+	}
+}
+
+func TestDeduplicatorEvictsOldestOverCapacity(t *testing.T) {
+	d := NewDeduplicator(2, time.Hour, 0)
+
+	d.Check(Metadata{MessageID: "a"})
+	d.Check(Metadata{MessageID: "b"})
+	d.Check(Metadata{MessageID: "c"})
+
+	if err := d.Check(Metadata{MessageID: "a"}); err != nil {
+		t.Errorf("evicted id: %v", err)
+	}
+	if err := d.Check(Metadata{MessageID: "c"}); !errors.Is(err, ErrDuplicateMessage) {
+		t.Errorf("kept id: got %v, want ErrDuplicateMessage", err)
+	}
+}
@@ -0,0 +1,204 @@
+package eventsub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrStopChain can be returned by a handler to prevent the remaining handlers
+// in the same chain from running. It is not reported as an error.
+var ErrStopChain = errors.New("stop handler chain")
+
+// HandlerFunc handles a decoded EventSub message
+type HandlerFunc func(ctx context.Context, msg *Message) error
+
+// HandlerID identifies a registered handler so it can be removed later
+type HandlerID uint64
+
+// Middleware wraps a HandlerFunc with additional behaviour (logging, recovery, filtering)
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// HandlerOption configures a single handler registration
+type HandlerOption func(*handlerEntry)
+
+// WithPriority sets the position of a handler in its chain. Handlers with a
+// lower priority run first; handlers with equal priority run in registration order.
+func WithPriority(priority int) HandlerOption {
+	return func(h *handlerEntry) {
+		h.priority = priority
+	}
+}
+
+// WithMiddleware wraps only this handler with the given middleware
+func WithMiddleware(middleware ...Middleware) HandlerOption {
+	return func(h *handlerEntry) {
+		h.middleware = append(h.middleware, middleware...)
+	}
+}
+
+type handlerEntry struct {
+	id         HandlerID
+	route      string
+	priority   int
+	handler    HandlerFunc
+	middleware []Middleware
+}
+
+// Dispatcher keeps ordered handler chains keyed by route and runs them with the
+// registered middleware applied
+type Dispatcher struct {
+	mu         sync.RWMutex
+	nextID     HandlerID
+	routes     map[string][]*handlerEntry
+	middleware []Middleware
+}
+
+// NewDispatcher creates an empty dispatcher
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		routes: make(map[string][]*handlerEntry),
+	}
+}
+
+// Use appends middleware that wraps every handler. The first middleware
+// registered is the outermost one.
+func (d *Dispatcher) Use(middleware ...Middleware) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.middleware = append(d.middleware, middleware...)
+}
+
+// Add registers a handler for a route and returns its id
+func (d *Dispatcher) Add(route string, handler HandlerFunc, options ...HandlerOption) HandlerID {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	entry := &handlerEntry{
+		id:      d.nextID,
+		route:   route,
+		handler: handler,
+	}
+	for _, option := range options {
+		option(entry)
+	}
+
+	// Chains are copied on write so Dispatch can iterate a snapshot without holding the lock
+	chain := make([]*handlerEntry, 0, len(d.routes[route])+1)
+	chain = append(chain, d.routes[route]...)
+	chain = append(chain, entry)
+	sort.SliceStable(chain, func(i, j int) bool {
+		return chain[i].priority < chain[j].priority
+	})
+	d.routes[route] = chain
+
+	return entry.id
+}
+
+// Remove unregisters the handler with the given id. It reports whether a
+// handler was found.
+func (d *Dispatcher) Remove(id HandlerID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for route, chain := range d.routes {
+		for i, entry := range chain {
+			if entry.id != id {
+				continue
+			}
+
+			chain = append(chain[:i:i], chain[i+1:]...)
+			if len(chain) == 0 {
+				delete(d.routes, route)
+			} else {
+				d.routes[route] = chain
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of handlers registered for a route
+func (d *Dispatcher) Len(route string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.routes[route])
+}
+
+// Dispatch runs every handler registered for the route in order. It reports
+// whether any handler was registered and joins the errors returned by them.
+func (d *Dispatcher) Dispatch(ctx context.Context, route string, msg *Message) (bool, error) {
+	d.mu.RLock()
+	chain := d.routes[route]
+	middleware := d.middleware
+	d.mu.RUnlock()
+
+	if len(chain) == 0 {
+		return false, nil
+	}
+
+	var errs []error
+	for _, entry := range chain {
+		handler := wrap(entry.handler, entry.middleware)
+		handler = wrap(handler, middleware)
+
+		err := handler(ctx, msg)
+		if errors.Is(err, ErrStopChain) {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return true, errors.Join(errs...)
+}
+
+// DispatchMessage runs the handlers registered for the message type and, for
+// notifications, the handlers registered for the subscription type. It reports
+// whether any handler ran.
+func (d *Dispatcher) DispatchMessage(ctx context.Context, msg *Message) (bool, error) {
+	handled, err := d.Dispatch(ctx, string(msg.Metadata.MessageType), msg)
+
+	if msg.Notification != nil {
+		notificationHandled, notificationErr := d.Dispatch(ctx, NotificationRoute(msg.Notification.Subscription.Type), msg)
+		handled = handled || notificationHandled
+		err = errors.Join(err, notificationErr)
+	}
+
+	return handled, err
+}
+
+// NotificationRoute returns the dispatcher route for an EventSub subscription type
+func NotificationRoute(subscriptionType string) string {
+	return "notification:" + subscriptionType
+}
+
+// EventHandler adapts a handler for one concrete event struct into a
+// HandlerFunc. Notifications carrying a different event are ignored.
+func EventHandler[T any](handler func(ctx context.Context, msg *Message, event *T) error) HandlerFunc {
+	return func(ctx context.Context, msg *Message) error {
+		if msg.Notification == nil {
+			return nil
+		}
+
+		event, ok := msg.Notification.Event.(*T)
+		if !ok {
+			return nil
+		}
+
+		return handler(ctx, msg, event)
+	}
+}
+
+// wrap applies middleware so that the first one in the list is the outermost
+func wrap(handler HandlerFunc, middleware []Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
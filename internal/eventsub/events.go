@@ -0,0 +1,178 @@
+package eventsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Subscription types with a concrete event struct in this package
+const (
+	SubscriptionChannelChatMessage = "channel.chat.message"
+)
+
+type eventKey struct {
+	subscriptionType string
+	version          string
+}
+
+var (
+	eventsMu sync.RWMutex
+	events   = map[eventKey]func() any{}
+)
+
+func init() {
+	RegisterEvent(SubscriptionChannelChatMessage, "1", func() any { return &ChannelChatMessage{} })
+}
+
+// RegisterEvent registers the struct that notifications of the given
+// subscription type and version are decoded into. The factory must return a pointer.
+func RegisterEvent(subscriptionType, version string, factory func() any) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	events[eventKey{subscriptionType, version}] = factory
+}
+
+// DecodeEvent decodes a raw notification event into its registered struct. It
+// returns nil without an error when no struct is registered for the type.
+func DecodeEvent(subscriptionType, version string, raw json.RawMessage) (any, error) {
+	eventsMu.RLock()
+	factory, ok := events[eventKey{subscriptionType, version}]
+	eventsMu.RUnlock()
+
+	if !ok || len(raw) == 0 {
+		return nil, nil
+	}
+
+	event := factory()
+	if err := json.Unmarshal(raw, event); err != nil {
+		return nil, fmt.Errorf("error parsing %s v%s event: %w", subscriptionType, version, err)
+	}
+
+	return event, nil
+}
+
+// ChatBadge is a chat badge displayed next to the chatter's name
+type ChatBadge struct {
+	SetID string `json:"set_id"`
+	ID    string `json:"id"`
+	Info  string `json:"info"`
+}
+
+// ChatCheermote describes a cheermote fragment
+type ChatCheermote struct {
+	Prefix string `json:"prefix"`
+	Bits   int    `json:"bits"`
+	Tier   int    `json:"tier"`
+}
+
+// ChatEmote describes an emote fragment
+type ChatEmote struct {
+	ID         string   `json:"id"`
+	EmoteSetID string   `json:"emote_set_id"`
+	OwnerID    string   `json:"owner_id"`
+	Format     []string `json:"format"`
+}
+
+// ChatMention describes a mention fragment
+type ChatMention struct {
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	UserLogin string `json:"user_login"`
+}
+
+// ChatMessageFragment is one piece of a chat message: text, cheermote, emote or mention
+type ChatMessageFragment struct {
+	Type      string         `json:"type"`
+	Text      string         `json:"text"`
+	Cheermote *ChatCheermote `json:"cheermote"`
+	Emote     *ChatEmote     `json:"emote"`
+	Mention   *ChatMention   `json:"mention"`
+}
+
+// ChatMessageBody is the text of a chat message split into fragments
+type ChatMessageBody struct {
+	Text      string                `json:"text"`
+	Fragments []ChatMessageFragment `json:"fragments"`
+}
+
+// ChatCheer is set when the chat message contains a cheer
+type ChatCheer struct {
+	Bits int `json:"bits"`
+}
+
+// ChatReply is set when the chat message is a reply to another message
+type ChatReply struct {
+	ParentMessageID   string `json:"parent_message_id"`
+	ParentMessageBody string `json:"parent_message_body"`
+	ParentUserID      string `json:"parent_user_id"`
+	ParentUserName    string `json:"parent_user_name"`
+	ParentUserLogin   string `json:"parent_user_login"`
+	ThreadMessageID   string `json:"thread_message_id"`
+	ThreadUserID      string `json:"thread_user_id"`
+	ThreadUserName    string `json:"thread_user_name"`
+	ThreadUserLogin   string `json:"thread_user_login"`
+}
+
+// ChannelChatMessage is the event of a channel.chat.message notification
+type ChannelChatMessage struct {
+	BroadcasterUserID           string          `json:"broadcaster_user_id"`
+	BroadcasterUserName         string          `json:"broadcaster_user_name"`
+	BroadcasterUserLogin        string          `json:"broadcaster_user_login"`
+	ChatterUserID               string          `json:"chatter_user_id"`
+	ChatterUserName             string          `json:"chatter_user_name"`
+	ChatterUserLogin            string          `json:"chatter_user_login"`
+	MessageID                   string          `json:"message_id"`
+	Message                     ChatMessageBody `json:"message"`
+	MessageType                 string          `json:"message_type"`
+	Badges                      []ChatBadge     `json:"badges"`
+	Cheer                       *ChatCheer      `json:"cheer"`
+	Color                       string          `json:"color"`
+	Reply                       *ChatReply      `json:"reply"`
+	ChannelPointsCustomRewardID string          `json:"channel_points_custom_reward_id"`
+	SourceBroadcasterUserID     string          `json:"source_broadcaster_user_id"`
+	SourceBroadcasterUserName   string          `json:"source_broadcaster_user_name"`
+	SourceBroadcasterUserLogin  string          `json:"source_broadcaster_user_login"`
+	SourceMessageID             string          `json:"source_message_id"`
+	SourceBadges                []ChatBadge     `json:"source_badges"`
+}
+
+// HasBadge reports whether the chatter has a badge from the given set, e.g. "moderator"
+func (m *ChannelChatMessage) HasBadge(setID string) bool {
+	for _, badge := range m.Badges {
+		if badge.SetID == setID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReply reports whether the message replies to another chat message
+func (m *ChannelChatMessage) IsReply() bool {
+	return m.Reply != nil
+}
+
+// ThreadID returns the id of the message that started the thread the message
+// belongs to, which is the message itself when it is not a reply
+func (m *ChannelChatMessage) ThreadID() string {
+	if m.Reply != nil && m.Reply.ThreadMessageID != "" {
+		return m.Reply.ThreadMessageID
+	}
+	return m.MessageID
+}
+
+// TextWithoutReplyMention returns the text without the "@login " mention of
+// the parent's author that Twitch puts in front of replies. The mention may
+// use the display name, so it is matched ignoring case.
+func (m *ChannelChatMessage) TextWithoutReplyMention() string {
+	text := m.Message.Text
+	if m.Reply == nil {
+		return text
+	}
+	mention := "@" + m.Reply.ParentUserLogin + " "
+	if len(text) >= len(mention) && strings.EqualFold(text[:len(mention)], mention) {
+		return text[len(mention):]
+	}
+	return text
+}
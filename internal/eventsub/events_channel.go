@@ -0,0 +1,242 @@
+package eventsub
+
+import "time"
+
+// Subscription types of the channel and stream events modelled below
+const (
+	SubscriptionChannelFollow              = "channel.follow"
+	SubscriptionChannelSubscribe           = "channel.subscribe"
+	SubscriptionChannelSubscriptionGift    = "channel.subscription.gift"
+	SubscriptionChannelSubscriptionMessage = "channel.subscription.message"
+	SubscriptionChannelCheer               = "channel.cheer"
+	SubscriptionChannelRaid                = "channel.raid"
+	SubscriptionChannelPointsRedemptionAdd = "channel.channel_points_custom_reward_redemption.add"
+	SubscriptionStreamOnline               = "stream.online"
+	SubscriptionStreamOffline              = "stream.offline"
+	SubscriptionChannelPollBegin           = "channel.poll.begin"
+	SubscriptionChannelPollProgress        = "channel.poll.progress"
+	SubscriptionChannelPollEnd             = "channel.poll.end"
+	SubscriptionChannelPredictionBegin     = "channel.prediction.begin"
+	SubscriptionChannelPredictionProgress  = "channel.prediction.progress"
+	SubscriptionChannelPredictionLock      = "channel.prediction.lock"
+	SubscriptionChannelPredictionEnd       = "channel.prediction.end"
+	SubscriptionChannelHypeTrainBegin      = "channel.hype_train.begin"
+	SubscriptionChannelHypeTrainProgress   = "channel.hype_train.progress"
+	SubscriptionChannelHypeTrainEnd        = "channel.hype_train.end"
+)
+
+func init() {
+	RegisterEvent(SubscriptionChannelFollow, "2", func() any { return &ChannelFollow{} })
+	RegisterEvent(SubscriptionChannelSubscribe, "1", func() any { return &ChannelSubscribe{} })
+	RegisterEvent(SubscriptionChannelSubscriptionGift, "1", func() any { return &ChannelSubscriptionGift{} })
+	RegisterEvent(SubscriptionChannelSubscriptionMessage, "1", func() any { return &ChannelSubscriptionMessage{} })
+	RegisterEvent(SubscriptionChannelCheer, "1", func() any { return &ChannelCheer{} })
+	RegisterEvent(SubscriptionChannelRaid, "1", func() any { return &ChannelRaid{} })
+	RegisterEvent(SubscriptionChannelPointsRedemptionAdd, "1", func() any { return &ChannelPointsRedemption{} })
+	RegisterEvent(SubscriptionStreamOnline, "1", func() any { return &StreamOnline{} })
+	RegisterEvent(SubscriptionStreamOffline, "1", func() any { return &StreamOffline{} })
+	for _, subscriptionType := range []string{SubscriptionChannelPollBegin, SubscriptionChannelPollProgress, SubscriptionChannelPollEnd} {
+		RegisterEvent(subscriptionType, "1", func() any { return &ChannelPoll{} })
+	}
+	for _, subscriptionType := range []string{SubscriptionChannelPredictionBegin, SubscriptionChannelPredictionProgress, SubscriptionChannelPredictionLock, SubscriptionChannelPredictionEnd} {
+		RegisterEvent(subscriptionType, "1", func() any { return &ChannelPrediction{} })
+	}
+	for _, subscriptionType := range []string{SubscriptionChannelHypeTrainBegin, SubscriptionChannelHypeTrainProgress, SubscriptionChannelHypeTrainEnd} {
+		RegisterEvent(subscriptionType, "1", func() any { return &ChannelHypeTrain{} })
+	}
+}
+
+// BroadcasterInfo identifies the channel an event happened in
+type BroadcasterInfo struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+}
+
+// UserInfo identifies the user that triggered an event. It is empty for anonymous events.
+type UserInfo struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+}
+
+// ChannelFollow is the event of a channel.follow notification
+type ChannelFollow struct {
+	UserInfo
+	BroadcasterInfo
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// ChannelSubscribe is the event of a channel.subscribe notification
+type ChannelSubscribe struct {
+	UserInfo
+	BroadcasterInfo
+	Tier   string `json:"tier"`
+	IsGift bool   `json:"is_gift"`
+}
+
+// ChannelSubscriptionGift is the event of a channel.subscription.gift notification
+type ChannelSubscriptionGift struct {
+	UserInfo
+	BroadcasterInfo
+	Total           int    `json:"total"`
+	Tier            string `json:"tier"`
+	CumulativeTotal int    `json:"cumulative_total"`
+	IsAnonymous     bool   `json:"is_anonymous"`
+}
+
+// SubscriptionMessageEmote is the position of an emote in a resubscription message
+type SubscriptionMessageEmote struct {
+	Begin int    `json:"begin"`
+	End   int    `json:"end"`
+	ID    string `json:"id"`
+}
+
+// ChannelSubscriptionMessage is the event of a channel.subscription.message notification
+type ChannelSubscriptionMessage struct {
+	UserInfo
+	BroadcasterInfo
+	Tier    string `json:"tier"`
+	Message struct {
+		Text   string                     `json:"text"`
+		Emotes []SubscriptionMessageEmote `json:"emotes"`
+	} `json:"message"`
+	CumulativeMonths int `json:"cumulative_months"`
+	StreakMonths     int `json:"streak_months"`
+	DurationMonths   int `json:"duration_months"`
+}
+
+// ChannelCheer is the event of a channel.cheer notification
+type ChannelCheer struct {
+	UserInfo
+	BroadcasterInfo
+	IsAnonymous bool   `json:"is_anonymous"`
+	Message     string `json:"message"`
+	Bits        int    `json:"bits"`
+}
+
+// ChannelRaid is the event of a channel.raid notification
+type ChannelRaid struct {
+	FromBroadcasterUserID    string `json:"from_broadcaster_user_id"`
+	FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+	FromBroadcasterUserName  string `json:"from_broadcaster_user_name"`
+	ToBroadcasterUserID      string `json:"to_broadcaster_user_id"`
+	ToBroadcasterUserLogin   string `json:"to_broadcaster_user_login"`
+	ToBroadcasterUserName    string `json:"to_broadcaster_user_name"`
+	Viewers                  int    `json:"viewers"`
+}
+
+// ChannelPointsReward is the custom reward that was redeemed
+type ChannelPointsReward struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Cost   int    `json:"cost"`
+	Prompt string `json:"prompt"`
+}
+
+// ChannelPointsRedemption is the event of a channel.channel_points_custom_reward_redemption.add notification
+type ChannelPointsRedemption struct {
+	UserInfo
+	BroadcasterInfo
+	ID         string              `json:"id"`
+	UserInput  string              `json:"user_input"`
+	Status     string              `json:"status"`
+	Reward     ChannelPointsReward `json:"reward"`
+	RedeemedAt time.Time           `json:"redeemed_at"`
+}
+
+// StreamOnline is the event of a stream.online notification
+type StreamOnline struct {
+	BroadcasterInfo
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// StreamOffline is the event of a stream.offline notification
+type StreamOffline struct {
+	BroadcasterInfo
+}
+
+// PollChoice is one of the answers of a poll
+type PollChoice struct {
+	ID                 string `json:"id"`
+	Title              string `json:"title"`
+	BitsVotes          int    `json:"bits_votes"`
+	ChannelPointsVotes int    `json:"channel_points_votes"`
+	Votes              int    `json:"votes"`
+}
+
+// PollVoting describes whether extra votes can be bought with bits or channel points
+type PollVoting struct {
+	IsEnabled     bool `json:"is_enabled"`
+	AmountPerVote int  `json:"amount_per_vote"`
+}
+
+// ChannelPoll is the event of the channel.poll.begin, progress and end notifications
+type ChannelPoll struct {
+	BroadcasterInfo
+	ID                  string       `json:"id"`
+	Title               string       `json:"title"`
+	Choices             []PollChoice `json:"choices"`
+	BitsVoting          PollVoting   `json:"bits_voting"`
+	ChannelPointsVoting PollVoting   `json:"channel_points_voting"`
+	Status              string       `json:"status"`
+	StartedAt           time.Time    `json:"started_at"`
+	EndsAt              *time.Time   `json:"ends_at"`
+	EndedAt             *time.Time   `json:"ended_at"`
+}
+
+// PredictionPredictor is one of the top predictors of an outcome
+type PredictionPredictor struct {
+	UserInfo
+	ChannelPointsWon  int `json:"channel_points_won"`
+	ChannelPointsUsed int `json:"channel_points_used"`
+}
+
+// PredictionOutcome is one of the outcomes of a prediction
+type PredictionOutcome struct {
+	ID            string                `json:"id"`
+	Title         string                `json:"title"`
+	Color         string                `json:"color"`
+	Users         int                   `json:"users"`
+	ChannelPoints int                   `json:"channel_points"`
+	TopPredictors []PredictionPredictor `json:"top_predictors"`
+}
+
+// ChannelPrediction is the event of the channel.prediction.begin, progress, lock and end notifications
+type ChannelPrediction struct {
+	BroadcasterInfo
+	ID               string              `json:"id"`
+	Title            string              `json:"title"`
+	Outcomes         []PredictionOutcome `json:"outcomes"`
+	WinningOutcomeID string              `json:"winning_outcome_id"`
+	Status           string              `json:"status"`
+	StartedAt        time.Time           `json:"started_at"`
+	LocksAt          *time.Time          `json:"locks_at"`
+	LockedAt         *time.Time          `json:"locked_at"`
+	EndedAt          *time.Time          `json:"ended_at"`
+}
+
+// HypeTrainContribution is a contribution towards a hype train
+type HypeTrainContribution struct {
+	UserInfo
+	Type  string `json:"type"`
+	Total int    `json:"total"`
+}
+
+// ChannelHypeTrain is the event of the channel.hype_train.begin, progress and end notifications
+type ChannelHypeTrain struct {
+	BroadcasterInfo
+	ID               string                  `json:"id"`
+	Level            int                     `json:"level"`
+	Total            int                     `json:"total"`
+	Progress         int                     `json:"progress"`
+	Goal             int                     `json:"goal"`
+	TopContributions []HypeTrainContribution `json:"top_contributions"`
+	LastContribution *HypeTrainContribution  `json:"last_contribution"`
+	StartedAt        time.Time               `json:"started_at"`
+	ExpiresAt        *time.Time              `json:"expires_at"`
+	EndedAt          *time.Time              `json:"ended_at"`
+	CooldownEndsAt   *time.Time              `json:"cooldown_ends_at"`
+}
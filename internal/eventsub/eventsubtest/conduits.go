@@ -0,0 +1,239 @@
+package eventsubtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+// maxShardCount is the most shards Twitch allows in a conduit
+const maxShardCount = 20000
+
+// Shard is a shard of a fake conduit and the transport it delivers to
+type Shard struct {
+	ID        string             `json:"id"`
+	Status    string             `json:"status"`
+	Transport eventsub.Transport `json:"transport"`
+}
+
+// Conduit is a conduit of the fake. Subscriptions with the conduit transport
+// are delivered through the sessions its enabled shards are assigned to.
+type Conduit struct {
+	ID     string
+	Shards []Shard
+}
+
+// Conduits returns every conduit the fake knows about
+func (s *Server) Conduits() []Conduit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conduits := make([]Conduit, 0, len(s.conduits))
+	for _, conduit := range s.conduits {
+		conduits = append(conduits, Conduit{
+			ID:     conduit.ID,
+			Shards: append([]Shard(nil), conduit.Shards...),
+		})
+	}
+	return conduits
+}
+
+type conduitJSON struct {
+	ID         string `json:"id"`
+	ShardCount int    `json:"shard_count"`
+}
+
+// serveConduits mirrors the /eventsub/conduits endpoints
+func (s *Server) serveConduits(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		data := make([]conduitJSON, 0, len(s.conduits))
+		for _, conduit := range s.conduits {
+			data = append(data, conduitJSON{conduit.ID, len(conduit.Shards)})
+		}
+		writeData(w, http.StatusOK, data)
+
+	case http.MethodPost:
+		var body conduitJSON
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ShardCount < 1 || body.ShardCount > maxShardCount {
+			writeError(w, http.StatusBadRequest, "invalid shard_count")
+			return
+		}
+		conduit := &Conduit{ID: newID()}
+		conduit.resize(body.ShardCount)
+		s.conduits = append(s.conduits, conduit)
+		writeData(w, http.StatusOK, []conduitJSON{{conduit.ID, len(conduit.Shards)}})
+
+	case http.MethodPatch:
+		var body conduitJSON
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ShardCount < 1 || body.ShardCount > maxShardCount {
+			writeError(w, http.StatusBadRequest, "invalid shard_count")
+			return
+		}
+		conduit := s.conduit(body.ID)
+		if conduit == nil {
+			writeError(w, http.StatusNotFound, "conduit not found")
+			return
+		}
+		conduit.resize(body.ShardCount)
+		writeData(w, http.StatusOK, []conduitJSON{{conduit.ID, len(conduit.Shards)}})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		for i, conduit := range s.conduits {
+			if conduit.ID != id {
+				continue
+			}
+			s.conduits = append(s.conduits[:i:i], s.conduits[i+1:]...)
+			// Deleting a conduit deletes its subscriptions
+			kept := s.subscriptions[:0]
+			for _, subscription := range s.subscriptions {
+				if subscription.Transport.ConduitID != id {
+					kept = append(kept, subscription)
+				}
+			}
+			s.subscriptions = kept
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeError(w, http.StatusNotFound, "conduit not found")
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// serveShards mirrors the /eventsub/conduits/shards endpoints, listing in a
+// single page
+func (s *Server) serveShards(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		conduit := s.conduit(query.Get("conduit_id"))
+		if conduit == nil {
+			writeError(w, http.StatusNotFound, "conduit not found")
+			return
+		}
+		data := []Shard{}
+		for _, shard := range conduit.Shards {
+			if status := query.Get("status"); status == "" || shard.Status == status {
+				data = append(data, shard)
+			}
+		}
+		writeData(w, http.StatusOK, data)
+
+	case http.MethodPatch:
+		var body struct {
+			ConduitID string  `json:"conduit_id"`
+			Shards    []Shard `json:"shards"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		conduit := s.conduit(body.ConduitID)
+		if conduit == nil {
+			writeError(w, http.StatusNotFound, "conduit not found")
+			return
+		}
+
+		data := []Shard{}
+		errs := []map[string]string{}
+		for _, update := range body.Shards {
+			shard, code, message := s.updateShard(conduit, update)
+			if shard == nil {
+				errs = append(errs, map[string]string{"id": update.ID, "code": code, "message": message})
+				continue
+			}
+			data = append(data, *shard)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{"data": data, "errors": errs})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// updateShard assigns the transport of update to a shard, or returns the
+// error code and message Twitch reports for it. Callers must hold s.mu.
+func (s *Server) updateShard(conduit *Conduit, update Shard) (*Shard, string, string) {
+	index, err := strconv.Atoi(update.ID)
+	if err != nil || index < 0 || index >= len(conduit.Shards) {
+		return nil, "not_found", "shard not found"
+	}
+
+	now := time.Now().UTC()
+	transport := eventsub.Transport{Method: update.Transport.Method, ConnectedAt: &now}
+	switch update.Transport.Method {
+	case "websocket":
+		if !s.isConnected(update.Transport.SessionID) {
+			return nil, "websocket_session_not_found", "unknown or disconnected websocket session"
+		}
+		transport.SessionID = update.Transport.SessionID
+	case "webhook":
+		transport.Callback = update.Transport.Callback
+	default:
+		return nil, "invalid_parameter", "unsupported transport method"
+	}
+
+	shard := &conduit.Shards[index]
+	shard.Status = "enabled"
+	shard.Transport = transport
+	return shard, "", ""
+}
+
+// resize adds disabled shards or drops the ones with the highest ids
+func (c *Conduit) resize(count int) {
+	for len(c.Shards) < count {
+		c.Shards = append(c.Shards, Shard{ID: strconv.Itoa(len(c.Shards)), Status: "disabled"})
+	}
+	c.Shards = c.Shards[:count]
+}
+
+// conduit finds a conduit by id. Callers must hold s.mu.
+func (s *Server) conduit(id string) *Conduit {
+	for _, conduit := range s.conduits {
+		if conduit.ID == id {
+			return conduit
+		}
+	}
+	return nil
+}
+
+// deliversTo reports whether notifications of a subscription go to a
+// session, directly or through an enabled shard of its conduit. Callers must
+// hold s.mu.
+func (s *Server) deliversTo(subscription *eventsub.Subscription, sessionID string) bool {
+	if subscription.Transport.Method != "conduit" {
+		return subscription.Transport.SessionID == sessionID
+	}
+
+	conduit := s.conduit(subscription.Transport.ConduitID)
+	if conduit == nil {
+		return false
+	}
+	for _, shard := range conduit.Shards {
+		if shard.Status == "enabled" && shard.Transport.SessionID == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+func writeData(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
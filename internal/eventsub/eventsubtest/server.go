@@ -0,0 +1,310 @@
+// Package eventsubtest provides an in-process fake of the Twitch EventSub
+// websocket server and of the Helix subscription and conduit endpoints, for
+// tests and offline development. Frames are only sent when the test asks for them, so
+// runs are deterministic.
+package eventsubtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/gorilla/websocket"
+)
+
+// Paths served by the fake. Point Config.EventsubWebsocketUrl at URL and the
+// Helix base URL at HelixURL.
+const (
+	WebsocketPath = "/ws"
+	HelixPath     = "/helix"
+)
+
+// ErrNoSubscription is returned when a frame needs a subscription the session does not have
+var ErrNoSubscription = errors.New("no such subscription on session")
+
+// Server is a fake EventSub server. Every websocket connection is welcomed
+// with a new session right away; all other frames are sent on command.
+type Server struct {
+	httpServer *httptest.Server
+	upgrader   websocket.Upgrader
+	keepalive  int
+
+	mu            sync.Mutex
+	sessions      []*Session
+	newSession    *sync.Cond
+	subscriptions []*eventsub.Subscription
+	conduits      []*Conduit
+	failures      []failure
+	maxTotalCost  int
+}
+
+// Option defines functional options for configuring the Server
+type Option func(*Server)
+
+// WithKeepaliveTimeout sets the keepalive_timeout_seconds announced in the welcome message
+func WithKeepaliveTimeout(seconds int) Option {
+	return func(s *Server) {
+		s.keepalive = seconds
+	}
+}
+
+// WithMaxTotalCost sets the cost limit of the fake subscription endpoint
+func WithMaxTotalCost(cost int) Option {
+	return func(s *Server) {
+		s.maxTotalCost = cost
+	}
+}
+
+// NewServer starts a fake EventSub server. Call Close when done.
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		keepalive:    10,
+		maxTotalCost: 10,
+	}
+	s.newSession = sync.NewCond(&s.mu)
+
+	for _, option := range options {
+		option(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(WebsocketPath, s.serveWebsocket)
+	mux.Handle(HelixPath+"/", s.HelixHandler())
+	s.httpServer = httptest.NewServer(mux)
+
+	return s
+}
+
+// URL returns the websocket URL of the fake
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.httpServer.URL, "http") + WebsocketPath
+}
+
+// HelixURL returns the base URL of the fake Helix endpoints
+func (s *Server) HelixURL() string {
+	return s.httpServer.URL + HelixPath
+}
+
+// Close disconnects every session and shuts the server down
+func (s *Server) Close() {
+	s.mu.Lock()
+	sessions := append([]*Session(nil), s.sessions...)
+	s.mu.Unlock()
+
+	for _, session := range sessions {
+		session.Close()
+	}
+	s.httpServer.Close()
+}
+
+// Sessions returns every session the server welcomed, oldest first
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Session(nil), s.sessions...)
+}
+
+// WaitForSession waits until the server welcomed the n-th session, counting
+// from 1, and returns it
+func (s *Server) WaitForSession(ctx context.Context, n int) (*Session, error) {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.newSession.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.sessions) < n {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s.newSession.Wait()
+	}
+	return s.sessions[n-1], nil
+}
+
+// Session is a websocket connection to the fake server
+type Session struct {
+	ID     string
+	server *Server
+	conn   *websocket.Conn
+	// writeMu serializes frames, gorilla connections allow a single writer
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (s *Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	session := &Session{
+		ID:     newID(),
+		server: s,
+		conn:   conn,
+		closed: make(chan struct{}),
+	}
+
+	// A session reached through a reconnect URL takes over the subscriptions
+	// and conduit shards of the session it replaces
+	if previous := r.URL.Query().Get("reconnect"); previous != "" {
+		s.moveSubscriptions(previous, session.ID)
+	}
+
+	// The session is known before the welcome, so the client can subscribe as soon as it arrives
+	s.mu.Lock()
+	s.sessions = append(s.sessions, session)
+	s.newSession.Broadcast()
+	s.mu.Unlock()
+
+	err = session.send(eventsub.MessageTypeSessionWelcome, nil, map[string]any{
+		"session": eventsub.Session{
+			ID:                      session.ID,
+			Status:                  "connected",
+			ConnectedAt:             time.Now().UTC(),
+			KeepaliveTimeoutSeconds: s.keepalive,
+		},
+	})
+	if err != nil {
+		session.Close()
+		return
+	}
+
+	// Clients never send frames, reading only notices the disconnect
+	go func() {
+		defer session.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// SendKeepalive sends a session_keepalive frame
+func (session *Session) SendKeepalive() error {
+	return session.send(eventsub.MessageTypeSessionKeepalive, nil, map[string]any{})
+}
+
+// SendNotification sends an event for one of the session's subscriptions,
+// found by type, including the subscriptions of a conduit with a shard
+// assigned to the session. The event is marshaled as the payload event object. It
+// returns the message id, so a test can redeliver the message with
+// SendNotificationWithID.
+func (session *Session) SendNotification(subscriptionType string, event any) (string, error) {
+	id := newID()
+	return id, session.SendNotificationWithID(id, subscriptionType, event)
+}
+
+// SendNotificationWithID is SendNotification with a given message id
+func (session *Session) SendNotificationWithID(id, subscriptionType string, event any) error {
+	subscription := session.server.findSubscription(session.ID, subscriptionType)
+	if subscription == nil {
+		return fmt.Errorf("%w: %s", ErrNoSubscription, subscriptionType)
+	}
+
+	rawEvent, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return session.sendWithID(id, eventsub.MessageTypeNotification, subscription, map[string]any{
+		"subscription": subscription,
+		"event":        json.RawMessage(rawEvent),
+	})
+}
+
+// SendReconnect sends a session_reconnect frame. The reconnect URL points
+// back at the server, and the session welcomed there takes over this
+// session's subscriptions. This session stays open until the client closes it.
+func (session *Session) SendReconnect() error {
+	return session.send(eventsub.MessageTypeSessionReconnect, nil, map[string]any{
+		"session": eventsub.Session{
+			ID:           session.ID,
+			Status:       "reconnecting",
+			ConnectedAt:  time.Now().UTC(),
+			ReconnectURL: session.server.URL() + "?reconnect=" + session.ID,
+		},
+	})
+}
+
+// SendRevocation revokes one of the session's subscriptions, found by type,
+// with status as the reason, e.g. eventsub.RevocationAuthorizationRevoked
+func (session *Session) SendRevocation(subscriptionType, status string) error {
+	subscription := session.server.revokeSubscription(session.ID, subscriptionType, status)
+	if subscription == nil {
+		return fmt.Errorf("%w: %s", ErrNoSubscription, subscriptionType)
+	}
+
+	return session.send(eventsub.MessageTypeRevocation, subscription, map[string]any{
+		"subscription": subscription,
+	})
+}
+
+// SendFrame sends a raw frame as is, e.g. a malformed one
+func (session *Session) SendFrame(frame []byte) error {
+	session.writeMu.Lock()
+	defer session.writeMu.Unlock()
+	return session.conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+// Close drops the connection, like Twitch does when a session ends. The
+// session's subscriptions and conduit shards become websocket_disconnected.
+func (session *Session) Close() {
+	session.closeOnce.Do(func() {
+		close(session.closed)
+		session.conn.Close()
+		session.server.disconnectSubscriptions(session.ID)
+	})
+}
+
+// Done is closed when the session ended
+func (session *Session) Done() <-chan struct{} {
+	return session.closed
+}
+
+func (session *Session) send(messageType eventsub.MessageType, subscription *eventsub.Subscription, payload map[string]any) error {
+	return session.sendWithID(newID(), messageType, subscription, payload)
+}
+
+func (session *Session) sendWithID(id string, messageType eventsub.MessageType, subscription *eventsub.Subscription, payload map[string]any) error {
+	metadata := eventsub.Metadata{
+		MessageID:        id,
+		MessageType:      messageType,
+		MessageTimestamp: time.Now().UTC(),
+	}
+	if subscription != nil {
+		metadata.SubscriptionType = subscription.Type
+		metadata.SubscriptionVersion = subscription.Version
+	}
+
+	frame, err := json.Marshal(map[string]any{
+		"metadata": metadata,
+		"payload":  payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	return session.SendFrame(frame)
+}
+
+// newID returns a random id in the format Twitch uses for sessions and messages
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,300 @@
+package eventsubtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+// failure is a scripted error response of the subscription endpoint
+type failure struct {
+	status  int
+	message string
+}
+
+// FailNextSubscriptions makes the next count subscription requests fail with
+// the given status, e.g. http.StatusUnauthorized or http.StatusTooManyRequests
+func (s *Server) FailNextSubscriptions(count, status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range count {
+		s.failures = append(s.failures, failure{status: status, message: message})
+	}
+}
+
+// Subscriptions returns every subscription the fake knows about, including
+// disconnected and revoked ones
+func (s *Server) Subscriptions() []eventsub.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriptions := make([]eventsub.Subscription, 0, len(s.subscriptions))
+	for _, subscription := range s.subscriptions {
+		subscriptions = append(subscriptions, *subscription)
+	}
+	return subscriptions
+}
+
+// SubscriptionsHandler returns the fake /eventsub/subscriptions endpoint, to
+// mount on another fake such as helixtest.Server
+func (s *Server) SubscriptionsHandler() http.Handler {
+	return http.HandlerFunc(s.serveSubscriptions)
+}
+
+// HelixHandler returns every fake Helix endpoint under HelixPath, the
+// subscription and the conduit ones, to mount on another fake such as
+// helixtest.Server
+func (s *Server) HelixHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HelixPath+"/eventsub/subscriptions", s.serveSubscriptions)
+	mux.HandleFunc(HelixPath+"/eventsub/conduits", s.serveConduits)
+	mux.HandleFunc(HelixPath+"/eventsub/conduits/shards", s.serveShards)
+	return mux
+}
+
+func (s *Server) serveSubscriptions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.failures) > 0 {
+		f := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, f.status, f.message)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.createSubscription(w, r)
+	case http.MethodGet:
+		s.listSubscriptions(w, r)
+	case http.MethodDelete:
+		s.deleteSubscription(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// createSubscription mirrors POST /eventsub/subscriptions for the websocket
+// and conduit transports. Callers must hold s.mu.
+func (s *Server) createSubscription(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		eventsub.SubscriptionRequest
+		Transport eventsub.Transport `json:"transport"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	transport := eventsub.Transport{Method: body.Transport.Method}
+	switch body.Transport.Method {
+	case "websocket":
+		if !s.isConnected(body.Transport.SessionID) {
+			writeError(w, http.StatusBadRequest, "unknown or disconnected websocket session")
+			return
+		}
+		transport.SessionID = body.Transport.SessionID
+		transport.ConnectedAt = &now
+	case "conduit":
+		if s.conduit(body.Transport.ConduitID) == nil {
+			writeError(w, http.StatusBadRequest, "unknown conduit")
+			return
+		}
+		transport.ConduitID = body.Transport.ConduitID
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported transport method")
+		return
+	}
+
+	for _, existing := range s.subscriptions {
+		if existing.Status == "enabled" &&
+			existing.Transport.SessionID == transport.SessionID &&
+			existing.Transport.ConduitID == transport.ConduitID &&
+			requestOf(existing).Key() == body.SubscriptionRequest.Key() {
+			writeError(w, http.StatusConflict, "subscription already exists")
+			return
+		}
+	}
+
+	if s.totalCost() >= s.maxTotalCost {
+		writeError(w, http.StatusTooManyRequests, "subscription cost limit exceeded")
+		return
+	}
+
+	subscription := &eventsub.Subscription{
+		ID:        newID(),
+		Status:    "enabled",
+		Type:      body.Type,
+		Version:   body.Version,
+		Cost:      1,
+		Condition: body.Condition,
+		Transport: transport,
+		CreatedAt: now,
+	}
+	s.subscriptions = append(s.subscriptions, subscription)
+
+	s.writeSubscriptions(w, http.StatusAccepted, []eventsub.Subscription{*subscription})
+}
+
+// listSubscriptions mirrors GET /eventsub/subscriptions in a single page.
+// Callers must hold s.mu.
+func (s *Server) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	data := []eventsub.Subscription{}
+	for _, subscription := range s.subscriptions {
+		if status := query.Get("status"); status != "" && subscription.Status != status {
+			continue
+		}
+		if subscriptionType := query.Get("type"); subscriptionType != "" && subscription.Type != subscriptionType {
+			continue
+		}
+		data = append(data, *subscription)
+	}
+
+	s.writeSubscriptions(w, http.StatusOK, data)
+}
+
+// deleteSubscription mirrors DELETE /eventsub/subscriptions. Callers must hold s.mu.
+func (s *Server) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	for i, subscription := range s.subscriptions {
+		if subscription.ID == id {
+			s.subscriptions = append(s.subscriptions[:i:i], s.subscriptions[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "subscription not found")
+}
+
+func (s *Server) writeSubscriptions(w http.ResponseWriter, status int, data []eventsub.Subscription) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"data":           data,
+		"total":          len(s.subscriptions),
+		"total_cost":     s.totalCost(),
+		"max_total_cost": s.maxTotalCost,
+		"pagination":     map[string]any{},
+	})
+}
+
+// totalCost adds up the cost of enabled subscriptions. Callers must hold s.mu.
+func (s *Server) totalCost() int {
+	cost := 0
+	for _, subscription := range s.subscriptions {
+		if subscription.Status == "enabled" {
+			cost += subscription.Cost
+		}
+	}
+	return cost
+}
+
+// isConnected reports whether the session is open. Callers must hold s.mu.
+func (s *Server) isConnected(sessionID string) bool {
+	for _, session := range s.sessions {
+		if session.ID != sessionID {
+			continue
+		}
+		select {
+		case <-session.closed:
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) findSubscription(sessionID, subscriptionType string) *eventsub.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, subscription := range s.subscriptions {
+		if subscription.Status == "enabled" &&
+			subscription.Type == subscriptionType &&
+			s.deliversTo(subscription, sessionID) {
+			copied := *subscription
+			return &copied
+		}
+	}
+	return nil
+}
+
+func (s *Server) revokeSubscription(sessionID, subscriptionType, status string) *eventsub.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, subscription := range s.subscriptions {
+		if subscription.Status == "enabled" &&
+			subscription.Type == subscriptionType &&
+			s.deliversTo(subscription, sessionID) {
+			subscription.Status = status
+			copied := *subscription
+			return &copied
+		}
+	}
+	return nil
+}
+
+func (s *Server) moveSubscriptions(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, subscription := range s.subscriptions {
+		if subscription.Transport.SessionID == from && subscription.Status == "enabled" {
+			subscription.Transport.SessionID = to
+		}
+	}
+	for _, conduit := range s.conduits {
+		for i := range conduit.Shards {
+			if shard := &conduit.Shards[i]; shard.Transport.SessionID == from && shard.Status == "enabled" {
+				shard.Transport.SessionID = to
+			}
+		}
+	}
+}
+
+func (s *Server) disconnectSubscriptions(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, subscription := range s.subscriptions {
+		if subscription.Transport.SessionID == sessionID && subscription.Status == "enabled" {
+			subscription.Status = "websocket_disconnected"
+			subscription.Transport.DisconnectedAt = &now
+		}
+	}
+	for _, conduit := range s.conduits {
+		for i := range conduit.Shards {
+			if shard := &conduit.Shards[i]; shard.Transport.SessionID == sessionID && shard.Status == "enabled" {
+				shard.Status = "websocket_disconnected"
+				shard.Transport.DisconnectedAt = &now
+			}
+		}
+	}
+}
+
+func requestOf(subscription *eventsub.Subscription) eventsub.SubscriptionRequest {
+	return eventsub.SubscriptionRequest{
+		Type:      subscription.Type,
+		Version:   subscription.Version,
+		Condition: subscription.Condition,
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   http.StatusText(status),
+		"status":  status,
+		"message": message,
+	})
+}
@@ -0,0 +1,41 @@
+package eventsub
+
+import "time"
+
+// Revocation reasons Twitch reports in the subscription status
+const (
+	RevocationAuthorizationRevoked = "authorization_revoked"
+	RevocationUserRemoved          = "user_removed"
+	RevocationVersionRemoved       = "version_removed"
+)
+
+// Reason returns why the subscription was revoked
+func (r *Revocation) Reason() string {
+	return r.Subscription.Status
+}
+
+// RequiresReauthorization reports whether the revocation can only be fixed by
+// the user authorizing the application again
+func (r *Revocation) RequiresReauthorization() bool {
+	return r.Subscription.Status == RevocationAuthorizationRevoked
+}
+
+// LifecycleEventType names a change in the state of a subscription
+type LifecycleEventType string
+
+const (
+	LifecycleSubscribed      LifecycleEventType = "subscribed"
+	LifecycleSubscribeFailed LifecycleEventType = "subscribe_failed"
+	LifecycleRevoked         LifecycleEventType = "revoked"
+)
+
+// LifecycleEvent reports a change in the state of a subscription, so the
+// application can tell the streamer when the bot lost access to an event
+type LifecycleEvent struct {
+	Type           LifecycleEventType
+	Request        SubscriptionRequest
+	SubscriptionID string
+	Reason         string // revocation reason, set for LifecycleRevoked
+	Err            error  // set for LifecycleSubscribeFailed
+	At             time.Time
+}
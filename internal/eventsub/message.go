@@ -0,0 +1,214 @@
+// Package eventsub contains the typed model of Twitch EventSub messages, the
+// decoder for websocket frames and the handler registry shared by transports.
+package eventsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var logger = utils.With(zap.String("component", "eventsub"))
+
+// MessageType is the metadata.message_type of an EventSub message
+type MessageType string
+
+const (
+	MessageTypeSessionWelcome   MessageType = "session_welcome"
+	MessageTypeSessionKeepalive MessageType = "session_keepalive"
+	MessageTypeNotification     MessageType = "notification"
+	MessageTypeSessionReconnect MessageType = "session_reconnect"
+	MessageTypeRevocation       MessageType = "revocation"
+
+	// MessageTypeWebhookCallbackVerification is only sent to webhook callbacks
+	MessageTypeWebhookCallbackVerification MessageType = "webhook_callback_verification"
+)
+
+// ErrUnknownMessageType is returned by Decode for message types this package does not model
+var ErrUnknownMessageType = errors.New("unknown eventsub message type")
+
+// Metadata is the metadata object sent with every EventSub message
+type Metadata struct {
+	MessageID           string      `json:"message_id"`
+	MessageType         MessageType `json:"message_type"`
+	MessageTimestamp    time.Time   `json:"message_timestamp"`
+	SubscriptionType    string      `json:"subscription_type,omitempty"`
+	SubscriptionVersion string      `json:"subscription_version,omitempty"`
+}
+
+// Session describes a websocket session
+type Session struct {
+	ID                      string    `json:"id"`
+	Status                  string    `json:"status"`
+	ConnectedAt             time.Time `json:"connected_at"`
+	KeepaliveTimeoutSeconds int       `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string    `json:"reconnect_url"`
+	RecoveryURL             string    `json:"recovery_url"`
+}
+
+// Transport describes how notifications for a subscription are delivered
+type Transport struct {
+	Method         string     `json:"method"`
+	SessionID      string     `json:"session_id,omitempty"`
+	Callback       string     `json:"callback,omitempty"`
+	Secret         string     `json:"secret,omitempty"`
+	ConduitID      string     `json:"conduit_id,omitempty"`
+	ConnectedAt    *time.Time `json:"connected_at,omitempty"`
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+}
+
+// Subscription is an EventSub subscription as sent in notification and revocation payloads
+type Subscription struct {
+	ID        string            `json:"id"`
+	Status    string            `json:"status"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Cost      int               `json:"cost"`
+	Condition map[string]string `json:"condition"`
+	Transport Transport         `json:"transport"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// SubscriptionRequest describes a subscription to create: its type, version and condition
+type SubscriptionRequest struct {
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+}
+
+// Key identifies the request by type, version and condition, so the same
+// subscription is not registered twice
+func (r SubscriptionRequest) Key() string {
+	keys := make([]string, 0, len(r.Condition))
+	for key := range r.Condition {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(r.Type + "@" + r.Version)
+	for _, key := range keys {
+		b.WriteString(";" + key + "=" + r.Condition[key])
+	}
+	return b.String()
+}
+
+// SessionWelcome is the first message sent after connecting to the websocket
+type SessionWelcome struct {
+	Session Session
+}
+
+// SessionKeepalive is sent when no notification was delivered within the keepalive timeout
+type SessionKeepalive struct{}
+
+// Notification carries an event for one of the session's subscriptions. Event
+// holds a pointer to the concrete event struct registered for the subscription
+// type and version, or nil when the type is unknown.
+type Notification struct {
+	Subscription Subscription
+	Event        any
+	RawEvent     json.RawMessage
+}
+
+// SessionReconnect asks the client to move to a new websocket URL
+type SessionReconnect struct {
+	Session Session
+}
+
+// Revocation reports that Twitch removed one of the session's subscriptions
+type Revocation struct {
+	Subscription Subscription
+}
+
+// Message is a decoded EventSub message. Exactly one of the typed payload
+// fields is set, matching Metadata.MessageType.
+type Message struct {
+	Metadata     Metadata
+	Welcome      *SessionWelcome
+	Keepalive    *SessionKeepalive
+	Notification *Notification
+	Reconnect    *SessionReconnect
+	Revocation   *Revocation
+	Raw          json.RawMessage
+}
+
+type frame struct {
+	Metadata Metadata `json:"metadata"`
+	Payload  struct {
+		Session      *Session        `json:"session"`
+		Subscription *Subscription   `json:"subscription"`
+		Event        json.RawMessage `json:"event"`
+	} `json:"payload"`
+}
+
+// Decode parses a raw websocket frame into a Message
+func Decode(data []byte) (*Message, error) {
+	var f frame
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("error parsing message: %w", err)
+	}
+
+	msg := &Message{
+		Metadata: f.Metadata,
+		Raw:      json.RawMessage(data),
+	}
+
+	switch f.Metadata.MessageType {
+	case MessageTypeSessionWelcome:
+		if f.Payload.Session == nil {
+			return nil, fmt.Errorf("session_welcome without session")
+		}
+		msg.Welcome = &SessionWelcome{Session: *f.Payload.Session}
+
+	case MessageTypeSessionKeepalive:
+		msg.Keepalive = &SessionKeepalive{}
+
+	case MessageTypeNotification:
+		if f.Payload.Subscription == nil {
+			return nil, fmt.Errorf("notification without subscription")
+		}
+		notification, err := NewNotification(*f.Payload.Subscription, f.Payload.Event)
+		if err != nil {
+			return nil, err
+		}
+		msg.Notification = notification
+
+	case MessageTypeSessionReconnect:
+		if f.Payload.Session == nil {
+			return nil, fmt.Errorf("session_reconnect without session")
+		}
+		msg.Reconnect = &SessionReconnect{Session: *f.Payload.Session}
+
+	case MessageTypeRevocation:
+		if f.Payload.Subscription == nil {
+			return nil, fmt.Errorf("revocation without subscription")
+		}
+		msg.Revocation = &Revocation{Subscription: *f.Payload.Subscription}
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMessageType, f.Metadata.MessageType)
+	}
+
+	return msg, nil
+}
+
+// NewNotification builds a Notification, decoding the raw event into the
+// struct registered for the subscription type and version
+func NewNotification(subscription Subscription, rawEvent json.RawMessage) (*Notification, error) {
+	event, err := DecodeEvent(subscription.Type, subscription.Version, rawEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notification{
+		Subscription: subscription,
+		Event:        event,
+		RawEvent:     rawEvent,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package eventsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RecoverMiddleware turns a panic inside a handler into an error so one broken
+// handler cannot take down the read loop or the rest of the chain
+func RecoverMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg *Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("handler panicked", zap.Any("panic", r), zap.Stack("stack"))
+					err = fmt.Errorf("handler panicked: %v", r)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// LoggingMiddleware logs the duration and result of every handler call
+func LoggingMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+
+			fields := []zap.Field{
+				zap.String("message_id", msg.Metadata.MessageID),
+				zap.String("message_type", string(msg.Metadata.MessageType)),
+				zap.String("subscription_type", msg.Metadata.SubscriptionType),
+				zap.Duration("duration", time.Since(start)),
+			}
+			if err != nil && !errors.Is(err, ErrStopChain) {
+				logger.Error("handler failed", append(fields, zap.Error(err))...)
+			} else {
+				logger.Debug("handler completed", fields...)
+			}
+
+			return err
+		}
+	}
+}
+
+// FilterMiddleware only calls the wrapped handler when the predicate returns true
+func FilterMiddleware(predicate func(ctx context.Context, msg *Message) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg *Message) error {
+			if !predicate(ctx, msg) {
+				return nil
+			}
+			return next(ctx, msg)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package eventsub
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// ProcessingMode selects how received messages are handed to handlers
+type ProcessingMode int
+
+const (
+	// ProcessInOrder handles messages one at a time in the order they arrived
+	ProcessInOrder ProcessingMode = iota
+	// ProcessByLane keeps messages with the same lane key (channel, user) in
+	// order while different lanes are handled in parallel
+	ProcessByLane
+	// ProcessPooled handles messages on a bounded pool of workers without any
+	// ordering guarantee
+	ProcessPooled
+)
+
+// OverflowPolicy decides what happens to a message when its queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the read loop wait until there is room in the queue
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued message to make room
+	OverflowDropOldest
+	// OverflowReject discards the new message
+	OverflowReject
+)
+
+// LaneFunc returns the ordering key of a notification for ProcessByLane.
+// Other messages skip the lanes, see Processor.
+type LaneFunc func(msg *Message) string
+
+// LaneByChannel orders messages per broadcaster
+func LaneByChannel(msg *Message) string {
+	if msg.Notification == nil {
+		return ""
+	}
+	return msg.Notification.Subscription.Condition["broadcaster_user_id"]
+}
+
+// LaneByUser orders chat messages per chatter and other notifications per broadcaster
+func LaneByUser(msg *Message) string {
+	if msg.Notification == nil {
+		return ""
+	}
+	if chat, ok := msg.Notification.Event.(*ChannelChatMessage); ok {
+		return chat.BroadcasterUserID + "/" + chat.ChatterUserID
+	}
+	return LaneByChannel(msg)
+}
+
+// ProcessorConfig configures how messages are queued and processed
+type ProcessorConfig struct {
+	Mode      ProcessingMode
+	Workers   int // number of lanes or pool workers; ignored by ProcessInOrder
+	QueueSize int // capacity of each queue
+	Overflow  OverflowPolicy
+	Lane      LaneFunc // defaults to LaneByChannel
+}
+
+// DefaultProcessorConfig processes messages strictly in order
+var DefaultProcessorConfig = ProcessorConfig{
+	Mode:      ProcessInOrder,
+	QueueSize: 1024,
+	Overflow:  OverflowBlock,
+}
+
+// ProcessorStats is a snapshot of the message queues
+type ProcessorStats struct {
+	QueueDepth    int    // messages waiting across all queues
+	MaxQueueDepth int    // highest depth seen since start
+	Processed     uint64 // messages handed to handlers
+	Dropped       uint64 // messages discarded by OverflowDropOldest
+	Rejected      uint64 // messages discarded by OverflowReject
+}
+
+// Processor feeds received messages to handlers through bounded queues. It
+// is shared by the websocket and webhook transports. Messages other than
+// notifications, such as session messages and revocations, have a queue and
+// worker of their own that never drops, so neither a burst of notifications
+// nor a slow handler delays or evicts them.
+type Processor struct {
+	config  ProcessorConfig
+	queues  []*messageQueue
+	control *messageQueue
+	handle  func(*Message)
+	wg      sync.WaitGroup
+	stopped atomic.Bool
+
+	depth     atomic.Int64
+	maxDepth  atomic.Int64
+	processed atomic.Uint64
+	dropped   atomic.Uint64
+	rejected  atomic.Uint64
+}
+
+// NewProcessor starts the workers that call handle for every submitted message
+func NewProcessor(config ProcessorConfig, handle func(*Message)) *Processor {
+	if config.Workers < 1 || config.Mode == ProcessInOrder {
+		config.Workers = 1
+	}
+	if config.QueueSize < 1 {
+		config.QueueSize = DefaultProcessorConfig.QueueSize
+	}
+	if config.Lane == nil {
+		config.Lane = LaneByChannel
+	}
+
+	p := &Processor{config: config, handle: handle}
+
+	// Lanes get a queue each; in-order and pooled processing share one queue
+	queues := 1
+	if config.Mode == ProcessByLane {
+		queues = config.Workers
+	}
+	for range queues {
+		p.queues = append(p.queues, newMessageQueue(config.QueueSize))
+	}
+
+	for i := range config.Workers {
+		queue := p.queues[i%len(p.queues)]
+		p.wg.Add(1)
+		go p.work(queue)
+	}
+
+	p.control = newMessageQueue(config.QueueSize)
+	p.wg.Add(1)
+	go p.work(p.control)
+
+	return p
+}
+
+// Submit queues a message, applying the overflow policy when the queue of a
+// notification is full
+func (p *Processor) Submit(msg *Message) {
+	if p.stopped.Load() {
+		return
+	}
+
+	queue, overflow := p.queues[0], p.config.Overflow
+	switch {
+	case msg.Notification == nil:
+		queue, overflow = p.control, OverflowBlock
+	case len(p.queues) > 1:
+		h := fnv.New32a()
+		h.Write([]byte(p.config.Lane(msg)))
+		queue = p.queues[h.Sum32()%uint32(len(p.queues))]
+	}
+
+	switch queue.push(msg, overflow) {
+	case pushAccepted:
+		p.trackDepth(1)
+	case pushDroppedOldest:
+		p.dropped.Add(1)
+	case pushRejected:
+		p.rejected.Add(1)
+	}
+}
+
+func (p *Processor) trackDepth(delta int64) {
+	depth := p.depth.Add(delta)
+	for {
+		highest := p.maxDepth.Load()
+		if depth <= highest || p.maxDepth.CompareAndSwap(highest, depth) {
+			return
+		}
+	}
+}
+
+func (p *Processor) work(queue *messageQueue) {
+	defer p.wg.Done()
+	for {
+		msg, ok := queue.pop()
+		if !ok {
+			return
+		}
+		p.trackDepth(-1)
+		p.handle(msg)
+		p.processed.Add(1)
+	}
+}
+
+// Stop closes the queues and waits for the messages already queued to be handled
+func (p *Processor) Stop() {
+	if p.stopped.Swap(true) {
+		return
+	}
+	for _, queue := range p.queues {
+		queue.close()
+	}
+	p.control.close()
+	p.wg.Wait()
+}
+
+// Stats returns a snapshot of the message queues
+func (p *Processor) Stats() ProcessorStats {
+	return ProcessorStats{
+		QueueDepth:    int(p.depth.Load()),
+		MaxQueueDepth: int(p.maxDepth.Load()),
+		Processed:     p.processed.Load(),
+		Dropped:       p.dropped.Load(),
+		Rejected:      p.rejected.Load(),
+	}
+}
+
+type pushResult int
+
+const (
+	pushAccepted pushResult = iota
+	pushDroppedOldest
+	pushRejected
+)
+
+// messageQueue is a bounded FIFO queue of messages
+type messageQueue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []*Message
+	capacity int
+	closed   bool
+}
+
+func newMessageQueue(capacity int) *messageQueue {
+	q := &messageQueue{capacity: capacity}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *messageQueue) push(msg *Message, overflow OverflowPolicy) pushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result := pushAccepted
+	for len(q.items) >= q.capacity && !q.closed {
+		switch overflow {
+		case OverflowReject:
+			return pushRejected
+		case OverflowDropOldest:
+			q.items = q.items[1:]
+			result = pushDroppedOldest
+		default:
+			q.notFull.Wait()
+		}
+	}
+	if q.closed {
+		return pushRejected
+	}
+
+	q.items = append(q.items, msg)
+	q.notEmpty.Signal()
+	return result
+}
+
+func (q *messageQueue) pop() (*Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.notEmpty.Wait()
+	}
+
+	msg := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	q.notFull.Signal()
+	return msg, true
+}
+
+func (q *messageQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
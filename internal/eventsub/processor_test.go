@@ -0,0 +1,246 @@
+package eventsub_test
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+func channelMessage(id, channel string) *eventsub.Message {
+	return &eventsub.Message{
+		Metadata: eventsub.Metadata{MessageID: id},
+		Notification: &eventsub.Notification{
+			Subscription: eventsub.Subscription{Condition: map[string]string{"broadcaster_user_id": channel}},
+		},
+	}
+}
+
+// recorder collects the ids of handled messages
+type recorder struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (r *recorder) handle(msg *eventsub.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids = append(r.ids, msg.Metadata.MessageID)
+}
+
+func (r *recorder) handled() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.ids)
+}
+
+func TestProcessInOrder(t *testing.T) {
+	var r recorder
+	p := eventsub.NewProcessor(eventsub.DefaultProcessorConfig, r.handle)
+
+	var want []string
+	for i := range 100 {
+		id := fmt.Sprint(i)
+		want = append(want, id)
+		p.Submit(channelMessage(id, "1"))
+	}
+	p.Stop()
+
+	if got := r.handled(); !slices.Equal(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+	if stats := p.Stats(); stats.Processed != 100 || stats.QueueDepth != 0 {
+		t.Errorf("stats %+v, want 100 processed and an empty queue", stats)
+	}
+}
+
+func TestProcessByLaneKeepsLaneOrder(t *testing.T) {
+	var r recorder
+	p := eventsub.NewProcessor(eventsub.ProcessorConfig{
+		Mode:      eventsub.ProcessByLane,
+		Workers:   4,
+		QueueSize: 16,
+	}, r.handle)
+
+	channels := []string{"1", "2", "3"}
+	for i := range 30 {
+		channel := channels[i%len(channels)]
+		p.Submit(channelMessage(channel+"/"+fmt.Sprint(i), channel))
+	}
+	p.Stop()
+
+	handled := r.handled()
+	if len(handled) != 30 {
+		t.Fatalf("handled %d messages, want 30", len(handled))
+	}
+	for _, channel := range channels {
+		var got, want []string
+		for _, id := range handled {
+			if id[:1] == channel {
+				got = append(got, id)
+			}
+		}
+		for i := range 30 {
+			if channels[i%len(channels)] == channel {
+				want = append(want, channel+"/"+fmt.Sprint(i))
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("channel %s handled %v, want %v", channel, got, want)
+		}
+	}
+}
+
+// laneKeys returns two lane keys that hash to different lanes
+func laneKeys(workers int) (string, string) {
+	lane := func(key string) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % uint32(workers)
+	}
+	for i := 1; ; i++ {
+		if key := fmt.Sprint(i); lane(key) != lane("0") {
+			return "0", key
+		}
+	}
+}
+
+func TestProcessByLaneDoesNotBlockOtherLanes(t *testing.T) {
+	slow, fast := laneKeys(4)
+
+	release := make(chan struct{})
+	handled := make(chan string, 2)
+	p := eventsub.NewProcessor(eventsub.ProcessorConfig{
+		Mode:      eventsub.ProcessByLane,
+		Workers:   4,
+		QueueSize: 16,
+	}, func(msg *eventsub.Message) {
+		if msg.Metadata.MessageID == "slow" {
+			<-release
+		}
+		handled <- msg.Metadata.MessageID
+	})
+
+	p.Submit(channelMessage("slow", slow))
+	p.Submit(channelMessage("fast", fast))
+
+	select {
+	case id := <-handled:
+		if id != "fast" {
+			t.Errorf("handled %q first, want fast", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a blocked lane held up another lane")
+	}
+
+	close(release)
+	p.Stop()
+}
+
+// blockedProcessor returns an in-order processor with a queue of one whose
+// worker is busy with the message "busy" until release is closed
+func blockedProcessor(t *testing.T, overflow eventsub.OverflowPolicy, r *recorder) (*eventsub.Processor, chan struct{}) {
+	t.Helper()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := eventsub.NewProcessor(eventsub.ProcessorConfig{
+		Mode:      eventsub.ProcessInOrder,
+		QueueSize: 1,
+		Overflow:  overflow,
+	}, func(msg *eventsub.Message) {
+		if msg.Metadata.MessageID == "busy" {
+			close(started)
+			<-release
+		}
+		r.handle(msg)
+	})
+
+	p.Submit(channelMessage("busy", "1"))
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not pick up the first message")
+	}
+	return p, release
+}
+
+func TestOverflowReject(t *testing.T) {
+	var r recorder
+	p, release := blockedProcessor(t, eventsub.OverflowReject, &r)
+
+	p.Submit(channelMessage("queued", "1"))
+	p.Submit(channelMessage("rejected", "1"))
+	close(release)
+	p.Stop()
+
+	if got, want := r.handled(), []string{"busy", "queued"}; !slices.Equal(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+	if stats := p.Stats(); stats.Rejected != 1 || stats.Dropped != 0 {
+		t.Errorf("stats %+v, want 1 rejected", stats)
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	var r recorder
+	p, release := blockedProcessor(t, eventsub.OverflowDropOldest, &r)
+
+	p.Submit(channelMessage("dropped", "1"))
+	p.Submit(channelMessage("newest", "1"))
+	close(release)
+	p.Stop()
+
+	if got, want := r.handled(), []string{"busy", "newest"}; !slices.Equal(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+	if stats := p.Stats(); stats.Dropped != 1 || stats.Rejected != 0 {
+		t.Errorf("stats %+v, want 1 dropped", stats)
+	}
+}
+
+func TestRevocationBypassesNotificationQueue(t *testing.T) {
+	var r recorder
+	p, release := blockedProcessor(t, eventsub.OverflowDropOldest, &r)
+
+	// The notification queue is busy and full, yet the revocation is neither
+	// dropped nor kept waiting
+	p.Submit(channelMessage("queued", "1"))
+	p.Submit(&eventsub.Message{
+		Metadata:   eventsub.Metadata{MessageID: "revocation"},
+		Revocation: &eventsub.Revocation{},
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for !slices.Contains(r.handled(), "revocation") {
+		if time.Now().After(deadline) {
+			t.Fatal("revocation waited behind a busy notification")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	p.Stop()
+
+	if got, want := r.handled(), []string{"revocation", "busy", "queued"}; !slices.Equal(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+	if stats := p.Stats(); stats.Dropped != 0 {
+		t.Errorf("stats %+v, want nothing dropped", stats)
+	}
+}
+
+func TestSubmitAfterStop(t *testing.T) {
+	var r recorder
+	p := eventsub.NewProcessor(eventsub.DefaultProcessorConfig, r.handle)
+	p.Stop()
+
+	p.Submit(channelMessage("late", "1"))
+	if got := r.handled(); len(got) != 0 {
+		t.Errorf("handled %v after Stop", got)
+	}
+}
@@ -0,0 +1,288 @@
+package helix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"go.uber.org/zap"
+)
+
+// ErrNoRefresh is returned by a TokenSource that cannot replace a rejected token
+var ErrNoRefresh = errors.New("access token cannot be refreshed")
+
+// RefreshMargin is how long before it expires a user access token is refreshed
+const RefreshMargin = 5 * time.Minute
+
+// Token is an OAuth token issued by the Twitch identity service
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Scopes       []string  `json:"scope,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the access token has expired. Tokens without an
+// expiry never do.
+func (t Token) Expired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)
+}
+
+// TokenSource supplies the access token sent with every request
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+	// Refresh replaces the token after Twitch rejected it. Concurrent
+	// refreshes of the same rejected token only refresh once.
+	Refresh(ctx context.Context, rejected string) (string, error)
+}
+
+// userTokenSource serves a user access token, refreshed with its refresh
+// token when there is one
+type userTokenSource struct {
+	appConfig  *config.Config
+	httpClient *http.Client
+	onRefresh  func(Token)
+
+	mu    sync.Mutex
+	token Token
+}
+
+// NewUserTokenSource creates a token source for a user access token. Without
+// an access token the one from the config is used. A token with a refresh
+// token is refreshed when it is about to expire; without one Refresh fails
+// with ErrNoRefresh. onRefresh, if not nil, is called with every refreshed
+// token, e.g. to store it.
+func NewUserTokenSource(appConfig *config.Config, httpClient *http.Client, token Token, onRefresh func(Token)) TokenSource {
+	return &userTokenSource{
+		appConfig:  appConfig,
+		httpClient: httpClient,
+		onRefresh:  onRefresh,
+		token:      token,
+	}
+}
+
+func (s *userTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.AccessToken == "" {
+		return s.appConfig.OauthToken, nil
+	}
+
+	expiring := !s.token.ExpiresAt.IsZero() && time.Until(s.token.ExpiresAt) < RefreshMargin
+	if expiring && s.token.RefreshToken != "" {
+		// The current token still works until it expires, and a 401 refreshes again
+		if _, err := s.refresh(ctx); err != nil {
+			logger.Warn("failed to refresh expiring access token", zap.Error(err))
+		}
+	}
+	return s.token.AccessToken, nil
+}
+
+func (s *userTokenSource) Refresh(ctx context.Context, rejected string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.AccessToken != "" && s.token.AccessToken != rejected {
+		return s.token.AccessToken, nil
+	}
+	return s.refresh(ctx)
+}
+
+// SetToken replaces the token, e.g. after the user authorized again
+func (s *userTokenSource) SetToken(token Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// refresh replaces the token using its refresh token. Callers must hold s.mu.
+func (s *userTokenSource) refresh(ctx context.Context) (string, error) {
+	if s.token.RefreshToken == "" {
+		return "", ErrNoRefresh
+	}
+
+	token, err := requestToken(ctx, s.appConfig, s.httpClient, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.token.RefreshToken},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.token = token
+	if s.onRefresh != nil {
+		s.onRefresh(token)
+	}
+	return token.AccessToken, nil
+}
+
+// appTokenSource serves an app access token from the client credentials
+// grant, requesting a new one when Twitch rejects it
+type appTokenSource struct {
+	appConfig  *config.Config
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+// NewAppTokenSource creates a token source for app access tokens, which
+// requires CLIENT_SECRET. The first token is requested when first needed.
+func NewAppTokenSource(appConfig *config.Config, httpClient *http.Client) TokenSource {
+	return &appTokenSource{appConfig: appConfig, httpClient: httpClient}
+}
+
+func (s *appTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" {
+		return s.token, nil
+	}
+	return s.fetch(ctx)
+}
+
+func (s *appTokenSource) Refresh(ctx context.Context, rejected string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != rejected {
+		return s.token, nil
+	}
+	return s.fetch(ctx)
+}
+
+// fetch requests a new app access token. Callers must hold s.mu.
+func (s *appTokenSource) fetch(ctx context.Context) (string, error) {
+	token, err := requestToken(ctx, s.appConfig, s.httpClient, url.Values{
+		"grant_type": {"client_credentials"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get app access token: %w", err)
+	}
+	s.token = token.AccessToken
+	return s.token, nil
+}
+
+// ExchangeCode redeems the code of the authorization code flow for a user
+// access token and refresh token. redirectURI must be the one the code was
+// requested with.
+func (c *Client) ExchangeCode(ctx context.Context, code, redirectURI string) (Token, error) {
+	token, err := requestToken(ctx, c.appConfig, c.httpClient, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	return token, nil
+}
+
+// RefreshToken replaces the token of the identity of ctx with a refreshed one
+// now, rather than waiting for it to expire
+func (c *Client) RefreshToken(ctx context.Context) error {
+	tokens := c.tokenSource(ctx)
+	token, err := tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = tokens.Refresh(ctx, token)
+	return err
+}
+
+// TokenSetter is implemented by token sources whose token can be replaced,
+// e.g. after the user authorized again
+type TokenSetter interface {
+	SetToken(token Token)
+}
+
+// ErrTokenNotSettable is returned by SetUserToken for a token source that is
+// not a TokenSetter
+var ErrTokenNotSettable = errors.New("token source cannot be replaced")
+
+// SetUserToken replaces the client's default user token, see SetIdentity
+// for the token of an identity
+func (c *Client) SetUserToken(token Token) error {
+	setter, ok := c.tokens.(TokenSetter)
+	if !ok {
+		return ErrTokenNotSettable
+	}
+	setter.SetToken(token)
+	return nil
+}
+
+// requestToken posts a grant to the token endpoint of the identity service
+func requestToken(ctx context.Context, appConfig *config.Config, httpClient *http.Client, form url.Values) (Token, error) {
+	form.Set("client_id", appConfig.ClientId)
+	form.Set("client_secret", appConfig.ClientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, appConfig.IdBaseUrl+"/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Token{}, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{}
+		json.Unmarshal(body, apiErr)
+		apiErr.StatusCode = resp.StatusCode
+		return Token{}, apiErr
+	}
+
+	var response struct {
+		AccessToken  string   `json:"access_token"`
+		RefreshToken string   `json:"refresh_token"`
+		ExpiresIn    int      `json:"expires_in"`
+		Scope        []string `json:"scope"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Token{}, fmt.Errorf("error parsing response: %w", err)
+	}
+
+	token := Token{
+		AccessToken:  response.AccessToken,
+		RefreshToken: response.RefreshToken,
+		Scopes:       response.Scope,
+	}
+	if response.ExpiresIn > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// TokenValidation describes the access token, as reported by /oauth2/validate
+type TokenValidation struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+// ValidateToken checks the access token of the identity of ctx with the
+// identity service
+func (c *Client) ValidateToken(ctx context.Context) (*TokenValidation, error) {
+	var validation TokenValidation
+	if err := c.send(ctx, http.MethodGet, c.appConfig.IdBaseUrl+"/oauth2/validate", nil, "OAuth", &validation); err != nil {
+		return nil, fmt.Errorf("failed to validate token: %w", err)
+	}
+	return &validation, nil
+}
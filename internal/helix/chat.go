@@ -0,0 +1,77 @@
+package helix
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ChatMessageRequest is the body of POST /chat/messages
+type ChatMessageRequest struct {
+	BroadcasterID        string `json:"broadcaster_id"`
+	SenderID             string `json:"sender_id"`
+	Message              string `json:"message"`
+	ReplyParentMessageID string `json:"reply_parent_message_id,omitempty"`
+}
+
+// DropReason explains why Twitch did not deliver a chat message
+type DropReason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ChatMessageResult reports whether a chat message was delivered. Twitch
+// answers 200 even for dropped messages, so IsSent must be checked.
+type ChatMessageResult struct {
+	MessageID  string      `json:"message_id"`
+	IsSent     bool        `json:"is_sent"`
+	DropReason *DropReason `json:"drop_reason"`
+}
+
+// SendChatMessage sends a message to a channel's chat, as the identity of the sender
+func (c *Client) SendChatMessage(ctx context.Context, request ChatMessageRequest) (*ChatMessageResult, error) {
+	ctx = c.asUser(ctx, request.SenderID)
+
+	var response struct {
+		Data []ChatMessageResult `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/chat/messages", nil, request, &response); err != nil {
+		return nil, fmt.Errorf("failed to send chat message: %w", err)
+	}
+
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("could not find chat message result")
+	}
+	return &response.Data[0], nil
+}
+
+// User is a Twitch user as returned by /users
+type User struct {
+	ID              string `json:"id"`
+	Login           string `json:"login"`
+	DisplayName     string `json:"display_name"`
+	Type            string `json:"type"`
+	BroadcasterType string `json:"broadcaster_type"`
+	ProfileImageURL string `json:"profile_image_url"`
+}
+
+// GetUsers looks users up by id and login. Without either it returns the
+// user the access token belongs to.
+func (c *Client) GetUsers(ctx context.Context, ids, logins []string) ([]User, error) {
+	query := url.Values{}
+	for _, id := range ids {
+		query.Add("id", id)
+	}
+	for _, login := range logins {
+		query.Add("login", login)
+	}
+
+	var response struct {
+		Data []User `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/users", query, nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return response.Data, nil
+}
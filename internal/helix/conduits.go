@@ -0,0 +1,140 @@
+package helix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+// Conduit spreads the notifications of its subscriptions over a number of
+// shards, each backed by its own transport. The conduit endpoints require an
+// app access token, see NewAppClient.
+type Conduit struct {
+	ID         string `json:"id"`
+	ShardCount int    `json:"shard_count"`
+}
+
+// ConduitShard is a single shard of a conduit and the transport it delivers to
+type ConduitShard struct {
+	ID        string             `json:"id"`
+	Status    string             `json:"status,omitempty"`
+	Transport eventsub.Transport `json:"transport"`
+}
+
+// ShardError reports why a shard could not be updated
+type ShardError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+func (e ShardError) Error() string {
+	return fmt.Sprintf("shard %s: %s: %s", e.ID, e.Code, e.Message)
+}
+
+type conduitsResponse struct {
+	Data []Conduit `json:"data"`
+}
+
+func (r conduitsResponse) first() (*Conduit, error) {
+	if len(r.Data) == 0 || r.Data[0].ID == "" {
+		return nil, fmt.Errorf("could not find conduit ID")
+	}
+	return &r.Data[0], nil
+}
+
+// ListConduits returns the application's conduits
+func (c *Client) ListConduits(ctx context.Context) ([]Conduit, error) {
+	var response conduitsResponse
+	if err := c.do(ctx, http.MethodGet, "/eventsub/conduits", nil, nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to list conduits: %w", err)
+	}
+	return response.Data, nil
+}
+
+// CreateConduit creates a conduit with the given number of shards
+func (c *Client) CreateConduit(ctx context.Context, shardCount int) (*Conduit, error) {
+	body := map[string]int{"shard_count": shardCount}
+
+	var response conduitsResponse
+	if err := c.do(ctx, http.MethodPost, "/eventsub/conduits", nil, body, &response); err != nil {
+		return nil, fmt.Errorf("failed to create conduit: %w", err)
+	}
+	return response.first()
+}
+
+// UpdateConduit changes the number of shards of a conduit. Shrinking it drops
+// the shards with the highest ids.
+func (c *Client) UpdateConduit(ctx context.Context, id string, shardCount int) (*Conduit, error) {
+	body := Conduit{ID: id, ShardCount: shardCount}
+
+	var response conduitsResponse
+	if err := c.do(ctx, http.MethodPatch, "/eventsub/conduits", nil, body, &response); err != nil {
+		return nil, fmt.Errorf("failed to update conduit %s: %w", id, err)
+	}
+	return response.first()
+}
+
+// DeleteConduit deletes a conduit together with its subscriptions
+func (c *Client) DeleteConduit(ctx context.Context, id string) error {
+	query := url.Values{"id": {id}}
+	if err := c.do(ctx, http.MethodDelete, "/eventsub/conduits", query, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete conduit %s: %w", id, err)
+	}
+	return nil
+}
+
+// ListConduitShards returns every shard of a conduit, optionally only the
+// ones with the given status
+func (c *Client) ListConduitShards(ctx context.Context, conduitID, status string) ([]ConduitShard, error) {
+	query := url.Values{"conduit_id": {conduitID}}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	var shards []ConduitShard
+	for {
+		var page struct {
+			Data       []ConduitShard `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/eventsub/conduits/shards", query, nil, &page); err != nil {
+			return nil, fmt.Errorf("failed to list shards of conduit %s: %w", conduitID, err)
+		}
+		shards = append(shards, page.Data...)
+
+		if page.Pagination.Cursor == "" {
+			return shards, nil
+		}
+		query.Set("after", page.Pagination.Cursor)
+	}
+}
+
+// UpdateConduitShards assigns transports to shards of a conduit. Shards that
+// could not be updated are returned joined as ShardError values.
+func (c *Client) UpdateConduitShards(ctx context.Context, conduitID string, shards []ConduitShard) ([]ConduitShard, error) {
+	body := struct {
+		ConduitID string         `json:"conduit_id"`
+		Shards    []ConduitShard `json:"shards"`
+	}{conduitID, shards}
+
+	var response struct {
+		Data   []ConduitShard `json:"data"`
+		Errors []ShardError   `json:"errors"`
+	}
+	if err := c.do(ctx, http.MethodPatch, "/eventsub/conduits/shards", nil, body, &response); err != nil {
+		return nil, fmt.Errorf("failed to update shards of conduit %s: %w", conduitID, err)
+	}
+
+	var errs []error
+	for _, shardErr := range response.Errors {
+		errs = append(errs, shardErr)
+	}
+	return response.Data, errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package helix_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix/helixtest"
+)
+
+func TestConduitShards(t *testing.T) {
+	fake := helixtest.NewServer()
+	defer fake.Close()
+	ctx := context.Background()
+	client, err := helix.NewAppClient(ctx, newConfig(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conduit, err := client.CreateConduit(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conduit.ShardCount != 2 {
+		t.Errorf("conduit %+v, want 2 shards", conduit)
+	}
+
+	// Shard 5 does not exist, the other update still applies
+	_, err = client.UpdateConduitShards(ctx, conduit.ID, []helix.ConduitShard{
+		{ID: "1", Transport: eventsub.Transport{Method: "websocket", SessionID: "session"}},
+		{ID: "5", Transport: eventsub.Transport{Method: "websocket", SessionID: "session"}},
+	})
+	var shardErr helix.ShardError
+	if !errors.As(err, &shardErr) || shardErr.ID != "5" {
+		t.Errorf("got %v, want an error for shard 5", err)
+	}
+
+	enabled, err := client.ListConduitShards(ctx, conduit.ID, "enabled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enabled) != 1 || enabled[0].ID != "1" || enabled[0].Transport.SessionID != "session" {
+		t.Errorf("enabled shards %+v, want shard 1", enabled)
+	}
+
+	if _, err := client.UpdateConduit(ctx, conduit.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if shards := fake.Shards(conduit.ID); len(shards) != 1 || shards[0].Status != "disabled" {
+		t.Errorf("shards after shrinking %+v, want shard 0 only", shards)
+	}
+
+	if err := client.DeleteConduit(ctx, conduit.ID); err != nil {
+		t.Fatal(err)
+	}
+	if conduits, err := client.ListConduits(ctx); err != nil || len(conduits) != 0 {
+		t.Errorf("conduits after delete %+v, %v", conduits, err)
+	}
+}
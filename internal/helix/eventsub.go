@@ -0,0 +1,126 @@
+package helix
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+type createSubscriptionBody struct {
+	Type      string             `json:"type"`
+	Version   string             `json:"version"`
+	Condition map[string]string  `json:"condition"`
+	Transport eventsub.Transport `json:"transport"`
+}
+
+// SubscriptionsResponse is the response of the EventSub subscription endpoints
+type SubscriptionsResponse struct {
+	Data         []eventsub.Subscription `json:"data"`
+	Total        int                     `json:"total"`
+	TotalCost    int                     `json:"total_cost"`
+	MaxTotalCost int                     `json:"max_total_cost"`
+	Pagination   struct {
+		Cursor string `json:"cursor"`
+	} `json:"pagination"`
+}
+
+// CreateEventSubSubscription creates a subscription delivered through the
+// given transport, authorized by the identity of the user in its condition.
+// Failures can be checked with errors.Is against ErrConflict, ErrMissingScope
+// and ErrRateLimited.
+func (c *Client) CreateEventSubSubscription(ctx context.Context, request eventsub.SubscriptionRequest, transport eventsub.Transport) (*eventsub.Subscription, error) {
+	ctx = c.asSubscriber(ctx, request)
+
+	body := createSubscriptionBody{
+		Type:      request.Type,
+		Version:   request.Version,
+		Condition: request.Condition,
+		Transport: transport,
+	}
+
+	var response SubscriptionsResponse
+	if err := c.do(ctx, http.MethodPost, "/eventsub/subscriptions", nil, body, &response); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", request.Type, err)
+	}
+
+	if len(response.Data) == 0 || response.Data[0].ID == "" {
+		return nil, fmt.Errorf("could not find subscription ID")
+	}
+
+	return &response.Data[0], nil
+}
+
+// SubscriptionFilter narrows down ListEventSubSubscriptions. Twitch filters by
+// only one field at a time; the others are applied to the returned pages.
+type SubscriptionFilter struct {
+	Status string
+	Type   string
+	UserID string
+}
+
+func (f SubscriptionFilter) matches(subscription eventsub.Subscription) bool {
+	if f.Status != "" && subscription.Status != f.Status {
+		return false
+	}
+	if f.Type != "" && subscription.Type != f.Type {
+		return false
+	}
+	if f.UserID != "" && !slices.Contains(slices.Collect(maps.Values(subscription.Condition)), f.UserID) {
+		return false
+	}
+	return true
+}
+
+// ListEventSubSubscriptions returns every page of the application's
+// subscriptions matching the filter. Total, TotalCost and MaxTotalCost are
+// taken from the first page.
+func (c *Client) ListEventSubSubscriptions(ctx context.Context, filter SubscriptionFilter) (*SubscriptionsResponse, error) {
+	query := url.Values{}
+	switch {
+	case filter.Status != "":
+		query.Set("status", filter.Status)
+	case filter.Type != "":
+		query.Set("type", filter.Type)
+	case filter.UserID != "":
+		query.Set("user_id", filter.UserID)
+	}
+
+	var all *SubscriptionsResponse
+	for {
+		var page SubscriptionsResponse
+		if err := c.do(ctx, http.MethodGet, "/eventsub/subscriptions", query, nil, &page); err != nil {
+			return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+		}
+
+		if all == nil {
+			all = &page
+		} else {
+			all.Data = append(all.Data, page.Data...)
+		}
+
+		if page.Pagination.Cursor == "" {
+			break
+		}
+		query.Set("after", page.Pagination.Cursor)
+	}
+
+	all.Pagination.Cursor = ""
+	all.Data = slices.DeleteFunc(all.Data, func(subscription eventsub.Subscription) bool {
+		return !filter.matches(subscription)
+	})
+	return all, nil
+}
+
+// DeleteEventSubSubscription deletes a subscription by id
+func (c *Client) DeleteEventSubSubscription(ctx context.Context, id string) error {
+	query := url.Values{"id": {id}}
+	if err := c.do(ctx, http.MethodDelete, "/eventsub/subscriptions", query, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete subscription %s: %w", id, err)
+	}
+	return nil
+}
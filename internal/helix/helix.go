@@ -0,0 +1,317 @@
+// Package helix calls the Twitch Helix API on behalf of the bot.
+package helix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var logger = utils.With(zap.String("component", "helix"))
+
+// APIError is an error response returned by the Twitch API. Use errors.Is
+// with the sentinel errors below to check for well-known failures.
+type APIError struct {
+	StatusCode int    `json:"status"`
+	ErrorName  string `json:"error"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("twitch api error %d %s: %s", e.StatusCode, e.ErrorName, e.Message)
+	}
+	return fmt.Sprintf("twitch api error %d", e.StatusCode)
+}
+
+var (
+	// ErrUnauthorized is matched by 401 responses: the token is invalid or expired
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrMissingScope is matched by 403 responses: the token lacks a required scope
+	ErrMissingScope = errors.New("missing authorization scope")
+	// ErrConflict is matched by 409 responses: the subscription already exists
+	ErrConflict = errors.New("subscription already exists")
+	// ErrRateLimited is matched by 429 responses: the rate limit or the
+	// subscription cost limit was exceeded
+	ErrRateLimited = errors.New("rate limit or cost limit exceeded")
+)
+
+// Is maps the status code of the response to the sentinel errors
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrMissingScope:
+		return e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// Default retry settings, see WithRetries
+const (
+	DefaultMaxRetries = 3
+	defaultRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+)
+
+// Client calls the Helix API with the application's client id and an access
+// token. It waits when the rate limit bucket is empty, retries 429 and 5xx
+// responses and refreshes the token once when Twitch rejects it.
+type Client struct {
+	appConfig   *config.Config
+	httpClient  *http.Client
+	tokens      TokenSource
+	limiter     *rateLimiter
+	maxRetries  int
+	retryDelay  time.Duration
+	authRetries int
+	userToken   Token
+	onRefresh   func(Token)
+
+	mu                sync.RWMutex
+	identities        map[Identity]userIdentity
+	pendingIdentities []func()
+}
+
+// Option defines functional options for configuring the Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for every request
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTokenSource sets where access tokens come from and how they are
+// refreshed. By default the user token from the config is used and never
+// refreshed, see WithUserToken.
+func WithTokenSource(tokens TokenSource) Option {
+	return func(c *Client) {
+		c.tokens = tokens
+	}
+}
+
+// WithUserToken authorizes the client with a user token from the
+// authorization code flow, which is refreshed with its refresh token.
+// onRefresh, if not nil, is called with every refreshed token.
+func WithUserToken(token Token, onRefresh func(Token)) Option {
+	return func(c *Client) {
+		c.userToken = token
+		c.onRefresh = onRefresh
+	}
+}
+
+// WithRetries sets how often a request failing with 429 or 5xx is retried,
+// and the delay before the first retry, doubled for every further one
+func WithRetries(maxRetries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryDelay = delay
+	}
+}
+
+// New creates a Helix client using the credentials from the config
+func New(appConfig *config.Config, options ...Option) *Client {
+	client := &Client{
+		appConfig:   appConfig,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		limiter:     &rateLimiter{},
+		maxRetries:  DefaultMaxRetries,
+		retryDelay:  defaultRetryDelay,
+		authRetries: 1,
+		identities:  make(map[Identity]userIdentity),
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	if client.tokens == nil {
+		client.tokens = NewUserTokenSource(appConfig, client.httpClient, client.userToken, client.onRefresh)
+	}
+
+	// Identities need the final HTTP client
+	for _, setIdentity := range client.pendingIdentities {
+		setIdentity()
+	}
+	client.pendingIdentities = nil
+
+	return client
+}
+
+// NewAppClient creates a Helix client authorized with an app access token
+// obtained through the client credentials grant. A new app token is requested
+// whenever Twitch rejects the current one.
+func NewAppClient(ctx context.Context, appConfig *config.Config, options ...Option) (*Client, error) {
+	client := New(appConfig, options...)
+	client.tokens = NewAppTokenSource(appConfig, client.httpClient)
+
+	if _, err := client.tokens.Token(ctx); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// do sends a request to a Helix endpoint and decodes the JSON response into
+// out when it is not nil. Responses with a status of 400 or above are returned
+// as *APIError.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body any, out any) error {
+	endpoint := c.appConfig.HelixBaseUrl + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var jsonBody []byte
+	if body != nil {
+		var err error
+		jsonBody, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshaling request body: %w", err)
+		}
+	}
+
+	return c.send(ctx, method, endpoint, jsonBody, "Bearer", out)
+}
+
+// send runs a request through the rate limiter, retries and token refresh.
+// scheme is the Authorization scheme: "Bearer" for Helix, "OAuth" for the
+// identity service. The token and rate limit bucket are the ones of the
+// identity of ctx.
+func (c *Client) send(ctx context.Context, method, endpoint string, jsonBody []byte, scheme string, out any) error {
+	id := c.identity(ctx)
+	tokens, limiter := id.tokens, id.limiter
+	// The identity service does not count against the Helix bucket
+	if scheme != "Bearer" {
+		limiter = nil
+	}
+
+	authRetries := c.authRetries
+	// attempt counts the retries of 429 and 5xx responses; retrying with a
+	// refreshed token is not one of them
+	attempt := 0
+	for {
+		if err := limiter.wait(ctx); err != nil {
+			return err
+		}
+
+		token, err := tokens.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting access token: %w", err)
+		}
+
+		var reader io.Reader
+		if jsonBody != nil {
+			reader = bytes.NewReader(jsonBody)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+		if err != nil {
+			return fmt.Errorf("error creating request: %w", err)
+		}
+
+		req.Header.Set("Authorization", scheme+" "+token)
+		req.Header.Set("Client-Id", c.appConfig.ClientId)
+		if jsonBody != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("error sending request: %w", err)
+		}
+
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error reading response body: %w", err)
+		}
+
+		limiter.update(resp.Header)
+
+		if resp.StatusCode < 400 {
+			if out != nil && len(respBody) > 0 {
+				if err := json.Unmarshal(respBody, out); err != nil {
+					return fmt.Errorf("error parsing response: %w", err)
+				}
+			}
+			return nil
+		}
+
+		apiErr := &APIError{}
+		json.Unmarshal(respBody, apiErr)
+		apiErr.StatusCode = resp.StatusCode
+
+		if resp.StatusCode == http.StatusUnauthorized && authRetries > 0 {
+			authRetries--
+			if _, refreshErr := tokens.Refresh(ctx, token); refreshErr == nil {
+				logger.Info("access token refreshed, retrying request", zap.String("endpoint", req.URL.Path))
+				continue
+			} else if !errors.Is(refreshErr, ErrNoRefresh) {
+				logger.Error("failed to refresh access token", zap.Error(refreshErr))
+			}
+		}
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if retryable && attempt < c.maxRetries {
+			delay := c.retryAfter(attempt, resp)
+			logger.Warn("helix request failed, retrying",
+				zap.String("method", method),
+				zap.String("endpoint", req.URL.Path),
+				zap.Int("status", resp.StatusCode),
+				zap.Int("attempt", attempt+1),
+				zap.Duration("delay", delay),
+			)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			attempt++
+			continue
+		}
+
+		logger.Error("helix request failed",
+			zap.String("method", method),
+			zap.String("endpoint", req.URL.Path),
+			zap.Int("status", resp.StatusCode),
+			zap.String("response", string(respBody)),
+		)
+		return apiErr
+	}
+}
+
+// retryAfter returns how long to wait before retrying a failed request. A
+// 429 waits until the rate limit bucket refills when Twitch says when.
+func (c *Client) retryAfter(attempt int, resp *http.Response) time.Duration {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if reset, ok := parseReset(resp.Header); ok {
+			if delay := time.Until(reset); delay > 0 {
+				return min(delay, maxRetryDelay)
+			}
+		}
+	}
+
+	delay := c.retryDelay << attempt
+	delay += time.Duration(rand.Int64N(int64(delay)/5 + 1))
+	return min(delay, maxRetryDelay)
+}
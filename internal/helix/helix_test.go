@@ -0,0 +1,160 @@
+package helix_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix/helixtest"
+)
+
+func newConfig(fake *helixtest.Server) *config.Config {
+	appConfig := &config.Config{ClientId: "client-id", ClientSecret: "client-secret"}
+	fake.Configure(appConfig)
+	return appConfig
+}
+
+func TestRefreshDoesNotUseRetry(t *testing.T) {
+	fake := helixtest.NewServer(helixtest.WithAccessTokens("current"))
+	defer fake.Close()
+
+	fake.Enqueue(http.MethodGet, "/helix/users",
+		helixtest.ErrorResponse(http.StatusUnauthorized, "Invalid OAuth token"),
+		helixtest.ErrorResponse(http.StatusServiceUnavailable, "Service Unavailable"),
+		helixtest.ErrorResponse(http.StatusServiceUnavailable, "Service Unavailable"),
+		helixtest.ErrorResponse(http.StatusServiceUnavailable, "Service Unavailable"),
+	)
+
+	client := helix.New(newConfig(fake),
+		helix.WithUserToken(helix.Token{AccessToken: "current", RefreshToken: "refresh"}, nil),
+		helix.WithRetries(3, time.Millisecond),
+	)
+
+	if _, err := client.GetUsers(context.Background(), []string{"1"}, nil); err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if got := len(fake.RequestsTo(http.MethodPost, "/oauth2/token")); got != 1 {
+		t.Errorf("token refreshed %d times, want 1", got)
+	}
+	if got := len(fake.RequestsTo(http.MethodGet, "/helix/users")); got != 5 {
+		t.Errorf("sent %d requests, want 5", got)
+	}
+}
+
+func TestRateLimitPerIdentity(t *testing.T) {
+	fake := helixtest.NewServer()
+	defer fake.Close()
+
+	// The bot's bucket is empty for an hour
+	fake.Enqueue(http.MethodGet, "/helix/users", helixtest.Response{
+		Status: http.StatusOK,
+		Header: http.Header{
+			"Ratelimit-Limit":     {"800"},
+			"Ratelimit-Remaining": {"0"},
+			"Ratelimit-Reset":     {strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+		},
+		Body: map[string]any{"data": []any{}},
+	})
+
+	client := helix.New(newConfig(fake),
+		helix.WithIdentity(helix.IdentityBot, "1", helix.Token{AccessToken: "bot"}, nil),
+		helix.WithIdentity(helix.IdentityBroadcaster, "2", helix.Token{AccessToken: "broadcaster"}, nil),
+	)
+
+	if _, err := client.GetUsers(context.Background(), []string{"1"}, nil); err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.GetUsers(helix.AsIdentity(ctx, helix.IdentityBroadcaster), []string{"2"}, nil); err != nil {
+		t.Errorf("broadcaster waited for the bot's bucket: %v", err)
+	}
+	if _, err := client.ValidateToken(ctx); err != nil {
+		t.Errorf("validation waited for the Helix bucket: %v", err)
+	}
+	if _, err := client.GetUsers(ctx, []string{"1"}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("bot request with an empty bucket: got %v, want deadline exceeded", err)
+	}
+}
+
+func TestRetryOnRetryableStatus(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			fake := helixtest.NewServer()
+			defer fake.Close()
+			fake.Fail(http.MethodGet, "/helix/users", status, http.StatusText(status))
+
+			client := helix.New(newConfig(fake), helix.WithRetries(2, time.Millisecond))
+			if _, err := client.GetUsers(context.Background(), []string{"1"}, nil); err != nil {
+				t.Fatalf("GetUsers: %v", err)
+			}
+			if got := len(fake.RequestsTo(http.MethodGet, "/helix/users")); got != 2 {
+				t.Errorf("sent %d requests, want 2", got)
+			}
+		})
+	}
+}
+
+func TestRetryWaitsForRateLimitReset(t *testing.T) {
+	fake := helixtest.NewServer()
+	defer fake.Close()
+
+	// The bucket refills within two seconds, long before the backoff delay
+	reset := time.Now().Truncate(time.Second).Add(2 * time.Second)
+	fake.RateLimit(http.MethodGet, "/helix/users", reset)
+
+	client := helix.New(newConfig(fake), helix.WithRetries(1, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.GetUsers(ctx, []string{"1"}, nil); err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+
+	requests := fake.RequestsTo(http.MethodGet, "/helix/users")
+	if len(requests) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(requests))
+	}
+	if now := time.Now(); now.Before(reset) {
+		t.Errorf("retried %v before the reset", reset.Sub(now))
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		requests int
+	}{
+		{"retryable status", http.StatusServiceUnavailable, 3},
+		{"client error", http.StatusBadRequest, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := helixtest.NewServer()
+			defer fake.Close()
+			for range 5 {
+				fake.Fail(http.MethodGet, "/helix/users", tt.status, http.StatusText(tt.status))
+			}
+
+			client := helix.New(newConfig(fake), helix.WithRetries(2, time.Millisecond))
+			_, err := client.GetUsers(context.Background(), []string{"1"}, nil)
+
+			var apiErr *helix.APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Errorf("got %v, want an APIError with status %d", err, tt.status)
+			}
+			if got := len(fake.RequestsTo(http.MethodGet, "/helix/users")); got != tt.requests {
+				t.Errorf("sent %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package helixtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+// Shard is a shard of a conduit of the fake
+type Shard struct {
+	ID        string             `json:"id"`
+	Status    string             `json:"status"`
+	Transport eventsub.Transport `json:"transport"`
+}
+
+// Shards returns the shards of a conduit, or nil for an unknown conduit
+func (s *Server) Shards(conduitID string) []Shard {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.conduits[conduitID])
+}
+
+type conduitJSON struct {
+	ID         string `json:"id"`
+	ShardCount int    `json:"shard_count"`
+}
+
+// serveConduits keeps conduits in memory, unless WithEventSub provided
+// another handler. Shards accept any transport.
+func (s *Server) serveConduits(w http.ResponseWriter, r *http.Request) {
+	if s.eventsub != nil {
+		s.eventsub.ServeHTTP(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		data := []conduitJSON{}
+		for id, shards := range s.conduits {
+			data = append(data, conduitJSON{id, len(shards)})
+		}
+		writeResponse(w, Response{Status: http.StatusOK, Body: map[string]any{"data": data}})
+
+	case http.MethodPost, http.MethodPatch:
+		var body conduitJSON
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ShardCount < 1 {
+			writeResponse(w, ErrorResponse(http.StatusBadRequest, "invalid shard_count"))
+			return
+		}
+		if r.Method == http.MethodPost {
+			body.ID = newID()
+		} else if _, ok := s.conduits[body.ID]; !ok {
+			writeResponse(w, ErrorResponse(http.StatusNotFound, "conduit not found"))
+			return
+		}
+
+		shards := s.conduits[body.ID]
+		for len(shards) < body.ShardCount {
+			shards = append(shards, Shard{ID: strconv.Itoa(len(shards)), Status: "disabled"})
+		}
+		s.conduits[body.ID] = shards[:body.ShardCount]
+		writeResponse(w, Response{Status: http.StatusOK, Body: map[string]any{"data": []conduitJSON{body}}})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if _, ok := s.conduits[id]; !ok {
+			writeResponse(w, ErrorResponse(http.StatusNotFound, "conduit not found"))
+			return
+		}
+		delete(s.conduits, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeResponse(w, ErrorResponse(http.StatusMethodNotAllowed, "method not allowed"))
+	}
+}
+
+// serveShards lists and assigns the shards of an in-memory conduit, unless
+// WithEventSub provided another handler
+func (s *Server) serveShards(w http.ResponseWriter, r *http.Request) {
+	if s.eventsub != nil {
+		s.eventsub.ServeHTTP(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		shards, ok := s.conduits[query.Get("conduit_id")]
+		if !ok {
+			writeResponse(w, ErrorResponse(http.StatusNotFound, "conduit not found"))
+			return
+		}
+		data := []Shard{}
+		for _, shard := range shards {
+			if status := query.Get("status"); status == "" || shard.Status == status {
+				data = append(data, shard)
+			}
+		}
+		writeResponse(w, Response{Status: http.StatusOK, Body: map[string]any{
+			"data":       data,
+			"pagination": map[string]any{},
+		}})
+
+	case http.MethodPatch:
+		var body struct {
+			ConduitID string  `json:"conduit_id"`
+			Shards    []Shard `json:"shards"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeResponse(w, ErrorResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
+		shards, ok := s.conduits[body.ConduitID]
+		if !ok {
+			writeResponse(w, ErrorResponse(http.StatusNotFound, "conduit not found"))
+			return
+		}
+
+		data := []Shard{}
+		errs := []map[string]string{}
+		for _, update := range body.Shards {
+			index, err := strconv.Atoi(update.ID)
+			if err != nil || index < 0 || index >= len(shards) {
+				errs = append(errs, map[string]string{"id": update.ID, "code": "not_found", "message": "shard not found"})
+				continue
+			}
+			update.Status = "enabled"
+			update.Transport.Secret = ""
+			shards[index] = update
+			data = append(data, update)
+		}
+		writeResponse(w, Response{Status: http.StatusAccepted, Body: map[string]any{"data": data, "errors": errs}})
+
+	default:
+		writeResponse(w, ErrorResponse(http.StatusMethodNotAllowed, "method not allowed"))
+	}
+}
@@ -0,0 +1,619 @@
+// Package helixtest provides an httptest based fake of the Twitch Helix API
+// and of the Twitch identity service, covering the endpoints the bot uses.
+// Every request is recorded, and responses can be scripted per endpoint to
+// simulate failures such as expired tokens or rate limits.
+package helixtest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+// HelixPath is the prefix of the fake Helix endpoints; the identity service
+// endpoints are served at the root
+const HelixPath = "/helix"
+
+// subscriptionsPageSize is how many subscriptions a page lists, as on Twitch
+const subscriptionsPageSize = 100
+
+// Request is a request received by the fake
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// Response is a scripted response. Body is marshaled as JSON unless it is a
+// []byte or nil.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   any
+}
+
+// TokenInfo is returned by the fake /oauth2/validate endpoint
+type TokenInfo struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+// User is returned by the fake /users and /moderation/moderators endpoints
+type User struct {
+	ID          string `json:"id"`
+	Login       string `json:"login"`
+	DisplayName string `json:"display_name"`
+}
+
+// ChatMessage is a message sent through the fake /chat/messages endpoint
+type ChatMessage struct {
+	BroadcasterID        string `json:"broadcaster_id"`
+	SenderID             string `json:"sender_id"`
+	Message              string `json:"message"`
+	ReplyParentMessageID string `json:"reply_parent_message_id,omitempty"`
+}
+
+// Ban is a ban or timeout created through the fake /moderation/bans endpoint
+type Ban struct {
+	BroadcasterID string
+	ModeratorID   string
+	UserID        string
+	Duration      int
+	Reason        string
+}
+
+// Server is a fake Helix API. Use Configure to point a config at it.
+type Server struct {
+	httpServer *httptest.Server
+	eventsub   http.Handler
+
+	mu            sync.Mutex
+	requests      []Request
+	scripted      map[string][]Response
+	tokens        map[string]bool
+	tokenInfo     TokenInfo
+	users         []User
+	moderators    []User
+	messages      []ChatMessage
+	bans          []Ban
+	subscriptions []eventsub.Subscription
+	conduits      map[string][]Shard
+}
+
+// Option defines functional options for configuring the Server
+type Option func(*Server)
+
+// WithAccessTokens makes the fake answer 401 to requests authorized with any
+// other token. Tokens issued by the fake /oauth2/token endpoint are accepted
+// too. Without this option every token is accepted.
+func WithAccessTokens(tokens ...string) Option {
+	return func(s *Server) {
+		for _, token := range tokens {
+			s.tokens[token] = true
+		}
+	}
+}
+
+// WithTokenInfo sets what /oauth2/validate reports about the token
+func WithTokenInfo(info TokenInfo) Option {
+	return func(s *Server) {
+		s.tokenInfo = info
+	}
+}
+
+// WithUsers sets the users known to the /users endpoint
+func WithUsers(users ...User) Option {
+	return func(s *Server) {
+		s.users = append(s.users, users...)
+	}
+}
+
+// WithModerators sets the moderators listed by /moderation/moderators
+func WithModerators(users ...User) Option {
+	return func(s *Server) {
+		s.moderators = append(s.moderators, users...)
+	}
+}
+
+// WithSubscriptions adds subscriptions to the in-memory store, e.g. ones in
+// the statuses Twitch leaves behind
+func WithSubscriptions(subscriptions ...eventsub.Subscription) Option {
+	return func(s *Server) {
+		s.subscriptions = append(s.subscriptions, subscriptions...)
+	}
+}
+
+// WithEventSub serves /eventsub/subscriptions and the conduit endpoints with
+// the given handler, e.g. eventsubtest.Server.HelixHandler, so subscriptions
+// and shards are tied to fake websocket sessions. Otherwise a simple
+// in-memory store is used.
+func WithEventSub(handler http.Handler) Option {
+	return func(s *Server) {
+		s.eventsub = handler
+	}
+}
+
+// NewServer starts a fake Helix API. Call Close when done.
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		scripted: make(map[string][]Response),
+		tokens:   make(map[string]bool),
+		conduits: make(map[string][]Shard),
+		tokenInfo: TokenInfo{
+			ClientID:  "client-id",
+			Login:     "bot",
+			UserID:    "1",
+			ExpiresIn: 3600,
+		},
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth2/validate", s.validate)
+	mux.HandleFunc("POST /oauth2/token", s.token)
+	mux.HandleFunc("POST "+HelixPath+"/chat/messages", s.sendChatMessage)
+	mux.HandleFunc("GET "+HelixPath+"/users", s.getUsers)
+	mux.HandleFunc("POST "+HelixPath+"/moderation/bans", s.banUser)
+	mux.HandleFunc("DELETE "+HelixPath+"/moderation/bans", s.unbanUser)
+	mux.HandleFunc("DELETE "+HelixPath+"/moderation/chat", s.deleteChatMessages)
+	mux.HandleFunc("GET "+HelixPath+"/moderation/moderators", s.getModerators)
+	mux.HandleFunc(HelixPath+"/eventsub/subscriptions", s.serveSubscriptions)
+	mux.HandleFunc(HelixPath+"/eventsub/conduits", s.serveConduits)
+	mux.HandleFunc(HelixPath+"/eventsub/conduits/shards", s.serveShards)
+
+	s.httpServer = httptest.NewServer(s.intercept(mux))
+	return s
+}
+
+// URL returns the base URL of the identity service endpoints
+func (s *Server) URL() string {
+	return s.httpServer.URL
+}
+
+// HelixURL returns the base URL of the Helix endpoints
+func (s *Server) HelixURL() string {
+	return s.httpServer.URL + HelixPath
+}
+
+// Configure points the Helix and identity service base URLs of a config at the fake
+func (s *Server) Configure(appConfig *config.Config) {
+	appConfig.HelixBaseUrl = s.HelixURL()
+	appConfig.IdBaseUrl = s.URL()
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Enqueue scripts the next responses of an endpoint, given by method and
+// path as requested, e.g. "POST", "/helix/chat/messages". Scripted responses
+// are used once each, in order, before the endpoint's regular behavior.
+func (s *Server) Enqueue(method, path string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := method + " " + path
+	s.scripted[key] = append(s.scripted[key], responses...)
+}
+
+// Fail scripts the next response of an endpoint as a Helix error
+func (s *Server) Fail(method, path string, status int, message string) {
+	s.Enqueue(method, path, ErrorResponse(status, message))
+}
+
+// RateLimit scripts the next response of an endpoint as a 429 with the rate
+// limit headers Twitch sends, the bucket refilling at reset
+func (s *Server) RateLimit(method, path string, reset time.Time) {
+	response := ErrorResponse(http.StatusTooManyRequests, "Too Many Requests")
+	response.Header = http.Header{
+		"Ratelimit-Limit":     {"800"},
+		"Ratelimit-Remaining": {"0"},
+		"Ratelimit-Reset":     {strconv.FormatInt(reset.Unix(), 10)},
+	}
+	s.Enqueue(method, path, response)
+}
+
+// ErrorResponse builds a response with the error body Helix uses
+func ErrorResponse(status int, message string) Response {
+	return Response{
+		Status: status,
+		Body: map[string]any{
+			"error":   http.StatusText(status),
+			"status":  status,
+			"message": message,
+		},
+	}
+}
+
+// Requests returns every request received so far, oldest first
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsTo returns the requests received by one endpoint
+func (s *Server) RequestsTo(method, path string) []Request {
+	var requests []Request
+	for _, request := range s.Requests() {
+		if request.Method == method && request.Path == path {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// Messages returns the chat messages sent so far
+func (s *Server) Messages() []ChatMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ChatMessage(nil), s.messages...)
+}
+
+// Subscriptions returns the subscriptions in the in-memory store
+func (s *Server) Subscriptions() []eventsub.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]eventsub.Subscription(nil), s.subscriptions...)
+}
+
+// Bans returns the bans and timeouts currently in place
+func (s *Server) Bans() []Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Ban(nil), s.bans...)
+}
+
+// intercept records every request, then answers it with a scripted response
+// or a 401 for an unknown token before handing it to the regular endpoints
+func (s *Server) intercept(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.Query(),
+			Header: r.Header.Clone(),
+			Body:   body,
+		})
+
+		key := r.Method + " " + r.URL.Path
+		if responses := s.scripted[key]; len(responses) > 0 {
+			s.scripted[key] = responses[1:]
+			s.mu.Unlock()
+			writeResponse(w, responses[0])
+			return
+		}
+
+		authorized := r.URL.Path == "/oauth2/token" || s.isAuthorized(r.Header.Get("Authorization"))
+		s.mu.Unlock()
+
+		if !authorized {
+			writeResponse(w, ErrorResponse(http.StatusUnauthorized, "Invalid OAuth token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAuthorized checks a "Bearer" or "OAuth" Authorization header. Callers
+// must hold s.mu.
+func (s *Server) isAuthorized(header string) bool {
+	if len(s.tokens) == 0 {
+		return true
+	}
+	_, token, _ := strings.Cut(header, " ")
+	return s.tokens[token]
+}
+
+func (s *Server) validate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	info := s.tokenInfo
+	s.mu.Unlock()
+
+	writeResponse(w, Response{Status: http.StatusOK, Body: info})
+}
+
+// token issues a token for the client credentials, authorization code and
+// refresh token grants
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	accessToken := newID()
+	s.mu.Lock()
+	if len(s.tokens) > 0 {
+		s.tokens[accessToken] = true
+	}
+	info := s.tokenInfo
+	s.mu.Unlock()
+
+	body := map[string]any{
+		"access_token": accessToken,
+		"expires_in":   info.ExpiresIn,
+		"token_type":   "bearer",
+	}
+	if r.Form.Get("grant_type") != "client_credentials" {
+		body["refresh_token"] = newID()
+		body["scope"] = info.Scopes
+	}
+
+	writeResponse(w, Response{Status: http.StatusOK, Body: body})
+}
+
+func (s *Server) sendChatMessage(w http.ResponseWriter, r *http.Request) {
+	var message ChatMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		writeResponse(w, ErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	s.messages = append(s.messages, message)
+	s.mu.Unlock()
+
+	writeResponse(w, Response{Status: http.StatusOK, Body: map[string]any{
+		"data": []map[string]any{{
+			"message_id":  newID(),
+			"is_sent":     true,
+			"drop_reason": nil,
+		}},
+	}})
+}
+
+// getUsers looks users up by id and login, defaulting to the token's user
+func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := []User{}
+	for _, user := range s.users {
+		switch {
+		case len(query["id"]) == 0 && len(query["login"]) == 0:
+			if user.ID == s.tokenInfo.UserID {
+				data = append(data, user)
+			}
+		case slices.Contains(query["id"], user.ID), slices.Contains(query["login"], user.Login):
+			data = append(data, user)
+		}
+	}
+
+	writeResponse(w, Response{Status: http.StatusOK, Body: map[string]any{"data": data}})
+}
+
+func (s *Server) banUser(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Data struct {
+			UserID   string `json:"user_id"`
+			Duration int    `json:"duration"`
+			Reason   string `json:"reason"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeResponse(w, ErrorResponse(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	ban := Ban{
+		BroadcasterID: r.URL.Query().Get("broadcaster_id"),
+		ModeratorID:   r.URL.Query().Get("moderator_id"),
+		UserID:        body.Data.UserID,
+		Duration:      body.Data.Duration,
+		Reason:        body.Data.Reason,
+	}
+
+	s.mu.Lock()
+	s.bans = append(s.bans, ban)
+	s.mu.Unlock()
+
+	now := time.Now().UTC()
+	var endTime *time.Time
+	if ban.Duration > 0 {
+		end := now.Add(time.Duration(ban.Duration) * time.Second)
+		endTime = &end
+	}
+
+	writeResponse(w, Response{Status: http.StatusOK, Body: map[string]any{
+		"data": []map[string]any{{
+			"broadcaster_id": ban.BroadcasterID,
+			"moderator_id":   ban.ModeratorID,
+			"user_id":        ban.UserID,
+			"created_at":     now,
+			"end_time":       endTime,
+		}},
+	}})
+}
+
+func (s *Server) unbanUser(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, ban := range s.bans {
+		if ban.BroadcasterID == query.Get("broadcaster_id") && ban.UserID == query.Get("user_id") {
+			s.bans = append(s.bans[:i:i], s.bans[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeResponse(w, ErrorResponse(http.StatusBadRequest, "The user is not banned"))
+}
+
+// deleteChatMessages accepts every deletion, the request is recorded for inspection
+func (s *Server) deleteChatMessages(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getModerators(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := make([]map[string]string, 0, len(s.moderators))
+	for _, user := range s.moderators {
+		data = append(data, map[string]string{
+			"user_id":    user.ID,
+			"user_login": user.Login,
+			"user_name":  user.DisplayName,
+		})
+	}
+
+	writeResponse(w, Response{Status: http.StatusOK, Body: map[string]any{
+		"data":       data,
+		"pagination": map[string]any{},
+	}})
+}
+
+// serveSubscriptions stores subscriptions of any transport in memory, unless
+// WithEventSub provided another handler. They are listed in pages, filtered
+// by status, type or user id like on Twitch.
+func (s *Server) serveSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if s.eventsub != nil {
+		s.eventsub.ServeHTTP(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPost:
+		var body struct {
+			eventsub.SubscriptionRequest
+			Transport eventsub.Transport `json:"transport"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeResponse(w, ErrorResponse(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		subscription := eventsub.Subscription{
+			ID:        newID(),
+			Status:    "enabled",
+			Type:      body.Type,
+			Version:   body.Version,
+			Cost:      1,
+			Condition: body.Condition,
+			Transport: body.Transport,
+			CreatedAt: time.Now().UTC(),
+		}
+		subscription.Transport.Secret = ""
+		s.subscriptions = append(s.subscriptions, subscription)
+		s.writeSubscriptions(w, http.StatusAccepted, []eventsub.Subscription{subscription}, "")
+
+	case http.MethodGet:
+		query := r.URL.Query()
+		listed := []eventsub.Subscription{}
+		for _, subscription := range s.subscriptions {
+			if status := query.Get("status"); status != "" && subscription.Status != status {
+				continue
+			}
+			if kind := query.Get("type"); kind != "" && subscription.Type != kind {
+				continue
+			}
+			if userID := query.Get("user_id"); userID != "" && !slices.Contains(slices.Collect(maps.Values(subscription.Condition)), userID) {
+				continue
+			}
+			listed = append(listed, subscription)
+		}
+
+		// The cursor is the index of the first subscription of the next page
+		start := 0
+		if after := query.Get("after"); after != "" {
+			var err error
+			if start, err = strconv.Atoi(after); err != nil || start < 0 || start > len(listed) {
+				writeResponse(w, ErrorResponse(http.StatusBadRequest, "invalid cursor"))
+				return
+			}
+		}
+		end := min(start+subscriptionsPageSize, len(listed))
+		cursor := ""
+		if end < len(listed) {
+			cursor = strconv.Itoa(end)
+		}
+		s.writeSubscriptions(w, http.StatusOK, listed[start:end], cursor)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		for i, subscription := range s.subscriptions {
+			if subscription.ID == id {
+				s.subscriptions = append(s.subscriptions[:i:i], s.subscriptions[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeResponse(w, ErrorResponse(http.StatusNotFound, "subscription not found"))
+
+	default:
+		writeResponse(w, ErrorResponse(http.StatusMethodNotAllowed, "method not allowed"))
+	}
+}
+
+// writeSubscriptions writes a subscription list response with the cursor of
+// the next page, if any. Callers must hold s.mu.
+func (s *Server) writeSubscriptions(w http.ResponseWriter, status int, data []eventsub.Subscription, cursor string) {
+	pagination := map[string]any{}
+	if cursor != "" {
+		pagination["cursor"] = cursor
+	}
+	writeResponse(w, Response{Status: status, Body: map[string]any{
+		"data":           data,
+		"total":          len(s.subscriptions),
+		"total_cost":     len(s.subscriptions),
+		"max_total_cost": 10000,
+		"pagination":     pagination,
+	}})
+}
+
+func writeResponse(w http.ResponseWriter, response Response) {
+	for key, values := range response.Header {
+		w.Header()[key] = values
+	}
+
+	var body []byte
+	switch b := response.Body.(type) {
+	case nil:
+	case []byte:
+		body = b
+	default:
+		body, _ = json.Marshal(b)
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	w.WriteHeader(response.Status)
+	w.Write(body)
+}
+
+// newID returns a random id for messages, subscriptions and tokens
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,141 @@
+package helix
+
+import (
+	"context"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+// Identity is a Twitch account the client can act as, each with its own
+// user token and scopes
+type Identity string
+
+const (
+	// IdentityBot is the bot account, which reads and sends chat
+	IdentityBot Identity = "bot"
+	// IdentityBroadcaster is the channel's owner, who authorizes channel
+	// points, subscriptions, polls and the like
+	IdentityBroadcaster Identity = "broadcaster"
+	// IdentityModerator is an optional moderator account for moderation calls
+	IdentityModerator Identity = "moderator"
+)
+
+// userIdentity is the token source of an identity and the user it belongs
+// to. Twitch keeps a rate limit bucket per token, so each has its own limiter.
+type userIdentity struct {
+	userID  string
+	tokens  TokenSource
+	limiter *rateLimiter
+}
+
+type identityKey struct{}
+
+// AsIdentity makes the calls made with ctx use the identity's token, rather
+// than the one the client picks
+func AsIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func identityFrom(ctx context.Context) Identity {
+	identity, _ := ctx.Value(identityKey{}).(Identity)
+	return identity
+}
+
+// WithIdentity authorizes the calls made as an identity with a user token of
+// userID, refreshed like the one of WithUserToken
+func WithIdentity(identity Identity, userID string, token Token, onRefresh func(Token)) Option {
+	return func(c *Client) {
+		c.pendingIdentities = append(c.pendingIdentities, func() {
+			c.SetIdentity(identity, userID, token, onRefresh)
+		})
+	}
+}
+
+// SetIdentity authorizes the calls made as an identity with a user token of
+// userID, replacing the identity's previous token
+func (c *Client) SetIdentity(identity Identity, userID string, token Token, onRefresh func(Token)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A new token of the same user draws from the same bucket
+	limiter := &rateLimiter{}
+	if previous, ok := c.identities[identity]; ok && previous.userID == userID {
+		limiter = previous.limiter
+	}
+
+	c.identities[identity] = userIdentity{
+		userID:  userID,
+		tokens:  NewUserTokenSource(c.appConfig, c.httpClient, token, onRefresh),
+		limiter: limiter,
+	}
+}
+
+// RemoveIdentity stops using an identity's token, e.g. after Twitch revoked it
+func (c *Client) RemoveIdentity(identity Identity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.identities, identity)
+}
+
+// HasIdentity reports whether the client has a token for an identity
+func (c *Client) HasIdentity(identity Identity) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.identities[identity]
+	return ok
+}
+
+// identity returns the token source and rate limiter for the identity of
+// ctx. Without one, or for an identity without a token, the bot's are used,
+// and without those the client's defaults.
+func (c *Client) identity(ctx context.Context) userIdentity {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if id, ok := c.identities[identityFrom(ctx)]; ok {
+		return id
+	}
+	if id, ok := c.identities[IdentityBot]; ok {
+		return id
+	}
+	return userIdentity{tokens: c.tokens, limiter: c.limiter}
+}
+
+// tokenSource returns the token source for the identity of ctx
+func (c *Client) tokenSource(ctx context.Context) TokenSource {
+	return c.identity(ctx).tokens
+}
+
+// asUser selects the identity of a user for the calls made with ctx, unless
+// ctx already has one or no identity belongs to the user
+func (c *Client) asUser(ctx context.Context, userID string) context.Context {
+	if identityFrom(ctx) != "" {
+		return ctx
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for identity, id := range c.identities {
+		if id.userID == userID {
+			return AsIdentity(ctx, identity)
+		}
+	}
+	return ctx
+}
+
+// conditionUserKeys are the condition fields naming the user who must
+// authorize a subscription, most specific first
+var conditionUserKeys = []string{"user_id", "moderator_user_id", "broadcaster_user_id", "to_broadcaster_user_id"}
+
+// asSubscriber selects the identity of the user who must authorize a
+// subscription, e.g. the bot for channel.chat.message and the broadcaster for
+// channel.subscribe
+func (c *Client) asSubscriber(ctx context.Context, request eventsub.SubscriptionRequest) context.Context {
+	for _, key := range conditionUserKeys {
+		if userID, ok := request.Condition[key]; ok {
+			if selected := c.asUser(ctx, userID); selected != ctx {
+				return selected
+			}
+		}
+	}
+	return ctx
+}
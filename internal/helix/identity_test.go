@@ -0,0 +1,159 @@
+package helix_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix/helixtest"
+)
+
+// identityClient has a token per identity, for the users 1, 2 and 3, except
+// the missing ones
+func identityClient(fake *helixtest.Server, missing ...helix.Identity) *helix.Client {
+	client := helix.New(newConfig(fake))
+	for _, id := range []struct {
+		identity helix.Identity
+		userID   string
+	}{
+		{helix.IdentityBot, "1"},
+		{helix.IdentityBroadcaster, "2"},
+		{helix.IdentityModerator, "3"},
+	} {
+		client.SetIdentity(id.identity, id.userID, helix.Token{AccessToken: string(id.identity)}, nil)
+	}
+	for _, identity := range missing {
+		client.RemoveIdentity(identity)
+	}
+	return client
+}
+
+// lastToken returns the access token of the last request to an endpoint
+func lastToken(t *testing.T, fake *helixtest.Server, method, path string) string {
+	t.Helper()
+	requests := fake.RequestsTo(method, path)
+	if len(requests) == 0 {
+		t.Fatalf("no request to %s %s", method, path)
+	}
+	return requests[len(requests)-1].Header.Get("Authorization")
+}
+
+func TestSubscriptionIdentity(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      string
+		condition map[string]string
+		missing   []helix.Identity
+		ctx       func(context.Context) context.Context
+		want      helix.Identity
+	}{
+		{
+			name:      "chat is read as the bot",
+			kind:      eventsub.SubscriptionChannelChatMessage,
+			condition: map[string]string{"broadcaster_user_id": "2", "user_id": "1"},
+			want:      helix.IdentityBot,
+		},
+		{
+			name:      "broadcaster events",
+			kind:      eventsub.SubscriptionChannelSubscribe,
+			condition: map[string]string{"broadcaster_user_id": "2"},
+			want:      helix.IdentityBroadcaster,
+		},
+		{
+			name:      "raids to the channel",
+			kind:      eventsub.SubscriptionChannelRaid,
+			condition: map[string]string{"to_broadcaster_user_id": "2"},
+			want:      helix.IdentityBroadcaster,
+		},
+		{
+			name:      "moderation as the moderator",
+			kind:      "channel.moderate",
+			condition: map[string]string{"broadcaster_user_id": "2", "moderator_user_id": "3"},
+			want:      helix.IdentityModerator,
+		},
+		{
+			name:      "moderation as the bot",
+			kind:      "channel.moderate",
+			condition: map[string]string{"broadcaster_user_id": "2", "moderator_user_id": "1"},
+			want:      helix.IdentityBot,
+		},
+		{
+			name:      "moderation without the moderator's token",
+			kind:      "channel.moderate",
+			condition: map[string]string{"broadcaster_user_id": "2", "moderator_user_id": "3"},
+			missing:   []helix.Identity{helix.IdentityModerator},
+			want:      helix.IdentityBroadcaster,
+		},
+		{
+			name:      "broadcaster events without the broadcaster's token",
+			kind:      eventsub.SubscriptionChannelSubscribe,
+			condition: map[string]string{"broadcaster_user_id": "2"},
+			missing:   []helix.Identity{helix.IdentityBroadcaster},
+			want:      helix.IdentityBot,
+		},
+		{
+			name:      "another channel",
+			kind:      eventsub.SubscriptionStreamOnline,
+			condition: map[string]string{"broadcaster_user_id": "9"},
+			want:      helix.IdentityBot,
+		},
+		{
+			name:      "identity chosen by the caller",
+			kind:      eventsub.SubscriptionChannelSubscribe,
+			condition: map[string]string{"broadcaster_user_id": "2"},
+			ctx: func(ctx context.Context) context.Context {
+				return helix.AsIdentity(ctx, helix.IdentityModerator)
+			},
+			want: helix.IdentityModerator,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := helixtest.NewServer()
+			defer fake.Close()
+			client := identityClient(fake, tt.missing...)
+
+			ctx := context.Background()
+			if tt.ctx != nil {
+				ctx = tt.ctx(ctx)
+			}
+			request := eventsub.SubscriptionRequest{Type: tt.kind, Version: "1", Condition: tt.condition}
+			if _, err := client.CreateEventSubSubscription(ctx, request, eventsub.Transport{Method: "websocket", SessionID: "session"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := lastToken(t, fake, http.MethodPost, "/helix/eventsub/subscriptions"); got != "Bearer "+string(tt.want) {
+				t.Errorf("authorized with %q, want the %s token", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatMessageIdentity(t *testing.T) {
+	tests := []struct {
+		sender  string
+		missing []helix.Identity
+		want    helix.Identity
+	}{
+		{"1", nil, helix.IdentityBot},
+		{"2", nil, helix.IdentityBroadcaster},
+		{"2", []helix.Identity{helix.IdentityBroadcaster}, helix.IdentityBot},
+	}
+
+	for _, tt := range tests {
+		fake := helixtest.NewServer()
+		client := identityClient(fake, tt.missing...)
+
+		request := helix.ChatMessageRequest{BroadcasterID: "2", SenderID: tt.sender, Message: "hello"}
+		if _, err := client.SendChatMessage(context.Background(), request); err != nil {
+			t.Fatal(err)
+		}
+		if got := lastToken(t, fake, http.MethodPost, "/helix/chat/messages"); got != "Bearer "+string(tt.want) {
+			t.Errorf("sender %s without %v: authorized with %q, want the %s token", tt.sender, tt.missing, got, tt.want)
+		}
+		fake.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package helix
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rateLimiter follows the token bucket Twitch reports in the Ratelimit-*
+// headers of every response. Once the bucket is empty, requests wait until
+// it is refilled instead of being rejected with a 429. A nil limiter never
+// waits.
+type rateLimiter struct {
+	mu        sync.Mutex
+	known     bool
+	remaining int
+	reset     time.Time
+}
+
+// update records the bucket state of a response
+func (l *rateLimiter) update(header http.Header) {
+	if l == nil {
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, ok := parseReset(header)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.known = true
+	l.remaining = remaining
+	l.reset = reset
+}
+
+// wait blocks until a request may be sent, taking a point from the bucket
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	if !l.known || l.remaining > 0 || time.Now().After(l.reset) {
+		if l.known && l.remaining > 0 {
+			l.remaining--
+		}
+		l.mu.Unlock()
+		return nil
+	}
+	delay := time.Until(l.reset)
+	l.mu.Unlock()
+
+	logger.Warn("helix rate limit reached, waiting", zap.Duration("delay", delay))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseReset reads the Ratelimit-Reset header, a unix timestamp in seconds
+func parseReset(header http.Header) (time.Time, bool) {
+	reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(reset, 0), true
+}
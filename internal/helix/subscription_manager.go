@@ -0,0 +1,143 @@
+package helix
+
+import (
+	"context"
+	"slices"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"go.uber.org/zap"
+)
+
+// Subscription statuses that keep delivering notifications. Every other status
+// means the subscription is dead but still counts towards the cost limit until
+// it is deleted.
+var liveStatuses = []string{
+	"enabled",
+	"webhook_callback_verification_pending",
+}
+
+// SubscriptionReport summarizes the application's EventSub subscriptions
+type SubscriptionReport struct {
+	Total        int
+	TotalCost    int
+	MaxTotalCost int
+	ByStatus     map[string]int
+	ByType       map[string]int
+}
+
+// PruneOptions configures SubscriptionManager.Prune
+type PruneOptions struct {
+	// KeepSessionIDs are websocket sessions in use by this process; their
+	// subscriptions are never deleted
+	KeepSessionIDs []string
+	// ForeignSessions also deletes enabled websocket subscriptions whose
+	// session is not in KeepSessionIDs, e.g. left over from a previous run
+	ForeignSessions bool
+	// DryRun only reports what would be deleted
+	DryRun bool
+}
+
+// PruneResult lists the subscriptions Prune deleted, or would delete on a dry run
+type PruneResult struct {
+	Deleted []eventsub.Subscription
+	Failed  []eventsub.Subscription
+	Report  SubscriptionReport
+}
+
+// SubscriptionManager inspects and garbage collects EventSub subscriptions
+type SubscriptionManager struct {
+	helix *Client
+}
+
+// NewSubscriptionManager creates a manager backed by the given Helix client
+func NewSubscriptionManager(helix *Client) *SubscriptionManager {
+	return &SubscriptionManager{helix: helix}
+}
+
+// List returns the subscriptions matching the filter
+func (m *SubscriptionManager) List(ctx context.Context, filter SubscriptionFilter) ([]eventsub.Subscription, error) {
+	response, err := m.helix.ListEventSubSubscriptions(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// Report lists every subscription and summarizes them together with the cost
+// Twitch charges for them
+func (m *SubscriptionManager) Report(ctx context.Context) (SubscriptionReport, error) {
+	response, err := m.helix.ListEventSubSubscriptions(ctx, SubscriptionFilter{})
+	if err != nil {
+		return SubscriptionReport{}, err
+	}
+	return newSubscriptionReport(response), nil
+}
+
+// Prune deletes subscriptions that can no longer deliver notifications, such
+// as the ones tied to disconnected websocket sessions
+func (m *SubscriptionManager) Prune(ctx context.Context, options PruneOptions) (*PruneResult, error) {
+	response, err := m.helix.ListEventSubSubscriptions(ctx, SubscriptionFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PruneResult{Report: newSubscriptionReport(response)}
+	for _, subscription := range response.Data {
+		if !isStale(subscription, options) {
+			continue
+		}
+
+		if options.DryRun {
+			result.Deleted = append(result.Deleted, subscription)
+			continue
+		}
+
+		if err := m.helix.DeleteEventSubSubscription(ctx, subscription.ID); err != nil {
+			logger.Error("failed to delete stale subscription",
+				zap.String("subscription_id", subscription.ID),
+				zap.Error(err),
+			)
+			result.Failed = append(result.Failed, subscription)
+			continue
+		}
+		result.Deleted = append(result.Deleted, subscription)
+	}
+
+	logger.Info("pruned eventsub subscriptions",
+		zap.Int("deleted", len(result.Deleted)),
+		zap.Int("failed", len(result.Failed)),
+		zap.Bool("dry_run", options.DryRun),
+		zap.Int("total_cost", result.Report.TotalCost),
+		zap.Int("max_total_cost", result.Report.MaxTotalCost),
+	)
+
+	return result, nil
+}
+
+func isStale(subscription eventsub.Subscription, options PruneOptions) bool {
+	sessionID := subscription.Transport.SessionID
+	if sessionID != "" && slices.Contains(options.KeepSessionIDs, sessionID) {
+		return false
+	}
+
+	if !slices.Contains(liveStatuses, subscription.Status) {
+		return true
+	}
+
+	return options.ForeignSessions && subscription.Transport.Method == "websocket"
+}
+
+func newSubscriptionReport(response *SubscriptionsResponse) SubscriptionReport {
+	report := SubscriptionReport{
+		Total:        response.Total,
+		TotalCost:    response.TotalCost,
+		MaxTotalCost: response.MaxTotalCost,
+		ByStatus:     make(map[string]int),
+		ByType:       make(map[string]int),
+	}
+	for _, subscription := range response.Data {
+		report.ByStatus[subscription.Status]++
+		report.ByType[subscription.Type]++
+	}
+	return report
+}
@@ -0,0 +1,160 @@
+package helix_test
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix/helixtest"
+)
+
+// leftovers are 250 subscriptions over three pages, cycling through live and
+// dead statuses. Even ones are on the session in use, odd ones on an old
+// session, and every tenth is a webhook.
+func leftovers() []eventsub.Subscription {
+	statuses := []string{
+		"enabled",
+		"websocket_disconnected",
+		"authorization_revoked",
+		"webhook_callback_verification_pending",
+		"user_removed",
+	}
+
+	var subscriptions []eventsub.Subscription
+	for i := range 250 {
+		transport := eventsub.Transport{Method: "websocket", SessionID: "old"}
+		switch {
+		case i%10 == 9:
+			transport = eventsub.Transport{Method: "webhook", Callback: "https://example.com/callback"}
+		case i%2 == 0:
+			transport.SessionID = "current"
+		}
+		subscriptions = append(subscriptions, eventsub.Subscription{
+			ID:        strconv.Itoa(i),
+			Status:    statuses[i%len(statuses)],
+			Type:      eventsub.SubscriptionChannelChatMessage,
+			Version:   "1",
+			Cost:      1,
+			Transport: transport,
+		})
+	}
+	return subscriptions
+}
+
+func ids(subscriptions []eventsub.Subscription) []string {
+	var ids []string
+	for _, subscription := range subscriptions {
+		ids = append(ids, subscription.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestSubscriptionManagerListsEveryPage(t *testing.T) {
+	fake := helixtest.NewServer(helixtest.WithSubscriptions(leftovers()...))
+	defer fake.Close()
+	manager := helix.NewSubscriptionManager(helix.New(newConfig(fake)))
+
+	report, err := manager.Report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 250 || report.ByStatus["enabled"] != 50 || report.ByType[eventsub.SubscriptionChannelChatMessage] != 250 {
+		t.Errorf("report %+v, want all 250 subscriptions", report)
+	}
+
+	requests := fake.RequestsTo(http.MethodGet, "/helix/eventsub/subscriptions")
+	if len(requests) != 3 {
+		t.Fatalf("listed %d pages, want 3", len(requests))
+	}
+	for i, want := range []string{"", "100", "200"} {
+		if after := requests[i].Query.Get("after"); after != want {
+			t.Errorf("page %d after %q, want %q", i, after, want)
+		}
+	}
+
+	// Twitch filters by status, the rest of the pages are still read
+	disconnected, err := manager.List(context.Background(), helix.SubscriptionFilter{Status: "websocket_disconnected"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disconnected) != 50 {
+		t.Errorf("listed %d disconnected subscriptions, want 50", len(disconnected))
+	}
+}
+
+func TestSubscriptionManagerPrune(t *testing.T) {
+	live := []string{"enabled", "webhook_callback_verification_pending"}
+
+	tests := []struct {
+		name    string
+		options helix.PruneOptions
+		// stale reports whether a subscription must be deleted
+		stale func(eventsub.Subscription) bool
+	}{
+		{
+			name:    "dead statuses",
+			options: helix.PruneOptions{KeepSessionIDs: []string{"current"}},
+			stale: func(subscription eventsub.Subscription) bool {
+				return subscription.Transport.SessionID != "current" && !slices.Contains(live, subscription.Status)
+			},
+		},
+		{
+			name:    "foreign sessions",
+			options: helix.PruneOptions{KeepSessionIDs: []string{"current"}, ForeignSessions: true},
+			stale: func(subscription eventsub.Subscription) bool {
+				if subscription.Transport.SessionID == "current" {
+					return false
+				}
+				return !slices.Contains(live, subscription.Status) || subscription.Transport.Method == "websocket"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscriptions := leftovers()
+			fake := helixtest.NewServer(helixtest.WithSubscriptions(subscriptions...))
+			defer fake.Close()
+			manager := helix.NewSubscriptionManager(helix.New(newConfig(fake)))
+
+			var stale, kept []eventsub.Subscription
+			for _, subscription := range subscriptions {
+				if tt.stale(subscription) {
+					stale = append(stale, subscription)
+				} else {
+					kept = append(kept, subscription)
+				}
+			}
+
+			// A dry run deletes nothing
+			dryRun := tt.options
+			dryRun.DryRun = true
+			result, err := manager.Prune(context.Background(), dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ids(result.Deleted), ids(stale)) {
+				t.Errorf("dry run would delete %v, want %v", ids(result.Deleted), ids(stale))
+			}
+			if got := len(fake.Subscriptions()); got != len(subscriptions) {
+				t.Fatalf("dry run left %d subscriptions, want %d", got, len(subscriptions))
+			}
+
+			result, err = manager.Prune(context.Background(), tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ids(result.Deleted), ids(stale)) || len(result.Failed) != 0 {
+				t.Errorf("deleted %v, failed %v, want %v", ids(result.Deleted), ids(result.Failed), ids(stale))
+			}
+			if got := ids(fake.Subscriptions()); !slices.Equal(got, ids(kept)) {
+				t.Errorf("left %v, want %v", got, ids(kept))
+			}
+		})
+	}
+}
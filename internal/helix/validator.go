@@ -0,0 +1,230 @@
+package helix
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Default validator settings; Twitch requires validating user tokens hourly
+const (
+	DefaultValidationInterval = time.Hour
+	DefaultExpiryWarning      = 15 * time.Minute
+	validationTimeout         = 30 * time.Second
+)
+
+// TokenStatus is the outcome of a token validation
+type TokenStatus string
+
+const (
+	// TokenValid means the token works for this app with every required scope
+	TokenValid TokenStatus = "valid"
+	// TokenExpiring means the token expires within the expiry warning. The
+	// validator refreshes it; Err is set when that failed.
+	TokenExpiring TokenStatus = "expiring"
+	// TokenMismatch means the token belongs to another client id or lacks
+	// required scopes, so the user must authorize again
+	TokenMismatch TokenStatus = "mismatch"
+	// TokenRevoked means Twitch rejected the token and it could not be refreshed
+	TokenRevoked TokenStatus = "revoked"
+	// TokenUnknown means the token could not be checked, e.g. Twitch was unreachable
+	TokenUnknown TokenStatus = "unknown"
+	// TokenMissing means the client has no token for the validated identity
+	TokenMissing TokenStatus = "missing"
+)
+
+// ValidationEvent reports a token validation
+type ValidationEvent struct {
+	Status        TokenStatus
+	Validation    *TokenValidation
+	MissingScopes []string
+	Err           error
+}
+
+// Validator checks the client's token periodically with /oauth2/validate
+type Validator struct {
+	client         *Client
+	interval       time.Duration
+	expiryWarning  time.Duration
+	requiredScopes []string
+	onEvent        func(ValidationEvent)
+	identity       Identity
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ValidatorOption defines functional options for configuring the Validator
+type ValidatorOption func(*Validator)
+
+// WithValidationInterval sets how often the token is checked
+func WithValidationInterval(interval time.Duration) ValidatorOption {
+	return func(v *Validator) {
+		v.interval = interval
+	}
+}
+
+// WithExpiryWarning sets how long before it expires a token is reported as
+// expiring and refreshed
+func WithExpiryWarning(warning time.Duration) ValidatorOption {
+	return func(v *Validator) {
+		v.expiryWarning = warning
+	}
+}
+
+// WithRequiredScopes sets the scopes the token must have
+func WithRequiredScopes(scopes ...string) ValidatorOption {
+	return func(v *Validator) {
+		v.requiredScopes = scopes
+	}
+}
+
+// WithValidatedIdentity checks the token of an identity rather than the
+// client's default one
+func WithValidatedIdentity(identity Identity) ValidatorOption {
+	return func(v *Validator) {
+		v.identity = identity
+	}
+}
+
+// WithOnValidation sets a function to be called with the outcome of every check
+func WithOnValidation(fn func(ValidationEvent)) ValidatorOption {
+	return func(v *Validator) {
+		v.onEvent = fn
+	}
+}
+
+// NewValidator creates a validator for the client's token
+func NewValidator(client *Client, options ...ValidatorOption) *Validator {
+	validator := &Validator{
+		client:        client,
+		interval:      DefaultValidationInterval,
+		expiryWarning: DefaultExpiryWarning,
+	}
+
+	for _, option := range options {
+		option(validator)
+	}
+
+	return validator
+}
+
+// Start checks the token every interval until Stop is called
+func (v *Validator) Start() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancel = cancel
+	v.done = make(chan struct{})
+
+	go func() {
+		defer close(v.done)
+
+		ticker := time.NewTicker(v.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkCtx, cancel := context.WithTimeout(ctx, validationTimeout)
+				v.Check(checkCtx)
+				cancel()
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic checks and waits for a running one
+func (v *Validator) Stop() {
+	v.mu.Lock()
+	cancel, done := v.cancel, v.done
+	v.cancel = nil
+	v.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// Check validates the token once, refreshing it when it is about to expire,
+// and reports the outcome to the callback
+func (v *Validator) Check(ctx context.Context) ValidationEvent {
+	event := v.check(ctx)
+
+	fields := []zap.Field{zap.String("status", string(event.Status))}
+	if v.identity != "" {
+		fields = append(fields, zap.String("identity", string(v.identity)))
+	}
+	if event.Validation != nil {
+		fields = append(fields, zap.String("user", event.Validation.Login), zap.Int("expires_in", event.Validation.ExpiresIn))
+	}
+	if len(event.MissingScopes) > 0 {
+		fields = append(fields, zap.Strings("missing_scopes", event.MissingScopes))
+	}
+	if event.Err != nil {
+		fields = append(fields, zap.Error(event.Err))
+	}
+	if event.Status == TokenValid {
+		logger.Info("token validated", fields...)
+	} else {
+		logger.Warn("token validation", fields...)
+	}
+
+	if v.onEvent != nil {
+		v.onEvent(event)
+	}
+	return event
+}
+
+func (v *Validator) check(ctx context.Context) ValidationEvent {
+	if v.identity != "" {
+		// Without its own token the identity would validate the bot's
+		if !v.client.HasIdentity(v.identity) {
+			return ValidationEvent{Status: TokenMissing}
+		}
+		ctx = AsIdentity(ctx, v.identity)
+	}
+
+	// A 401 is already retried with a refreshed token
+	validation, err := v.client.ValidateToken(ctx)
+	if errors.Is(err, ErrUnauthorized) {
+		return ValidationEvent{Status: TokenRevoked, Err: err}
+	}
+	if err != nil {
+		return ValidationEvent{Status: TokenUnknown, Err: err}
+	}
+
+	event := ValidationEvent{Status: TokenValid, Validation: validation}
+
+	for _, scope := range v.requiredScopes {
+		if !slices.Contains(validation.Scopes, scope) {
+			event.MissingScopes = append(event.MissingScopes, scope)
+		}
+	}
+	if validation.ClientID != v.client.appConfig.ClientId || len(event.MissingScopes) > 0 {
+		event.Status = TokenMismatch
+		return event
+	}
+
+	// App tokens and tokens without an expiry report 0
+	if validation.ExpiresIn > 0 && time.Duration(validation.ExpiresIn)*time.Second < v.expiryWarning {
+		event.Status = TokenExpiring
+		if err := v.client.RefreshToken(ctx); err != nil {
+			event.Err = err
+		}
+	}
+
+	return event
+}
@@ -0,0 +1,131 @@
+package helix_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix/helixtest"
+)
+
+func TestValidatorCheck(t *testing.T) {
+	readScopes := []string{"user:read:chat"}
+	chatScopes := []string{"user:read:chat", "user:write:chat"}
+
+	tests := []struct {
+		name    string
+		info    helixtest.TokenInfo
+		token   helix.Token
+		want    helix.TokenStatus
+		missing []string
+		err     bool
+		// refreshed is whether the validator refreshed the token
+		refreshed bool
+	}{
+		{
+			name:  "valid",
+			info:  helixtest.TokenInfo{ClientID: "client-id", Login: "bot", UserID: "1", Scopes: chatScopes, ExpiresIn: 3600},
+			token: helix.Token{AccessToken: "current"},
+			want:  helix.TokenValid,
+		},
+		{
+			name:  "expired and not refreshable",
+			info:  helixtest.TokenInfo{ClientID: "client-id", Login: "bot", UserID: "1", Scopes: chatScopes, ExpiresIn: 3600},
+			token: helix.Token{AccessToken: "expired"},
+			want:  helix.TokenRevoked,
+			err:   true,
+		},
+		{
+			name:      "expired and refreshed",
+			info:      helixtest.TokenInfo{ClientID: "client-id", Login: "bot", UserID: "1", Scopes: chatScopes, ExpiresIn: 3600},
+			token:     helix.Token{AccessToken: "expired", RefreshToken: "refresh"},
+			want:      helix.TokenValid,
+			refreshed: true,
+		},
+		{
+			name:    "missing scope",
+			info:    helixtest.TokenInfo{ClientID: "client-id", Login: "bot", UserID: "1", Scopes: readScopes, ExpiresIn: 3600},
+			token:   helix.Token{AccessToken: "current"},
+			want:    helix.TokenMismatch,
+			missing: []string{"user:write:chat"},
+		},
+		{
+			name:  "other client",
+			info:  helixtest.TokenInfo{ClientID: "other-client", Login: "bot", UserID: "1", Scopes: chatScopes, ExpiresIn: 3600},
+			token: helix.Token{AccessToken: "current"},
+			want:  helix.TokenMismatch,
+		},
+		{
+			name:      "expiring",
+			info:      helixtest.TokenInfo{ClientID: "client-id", Login: "bot", UserID: "1", Scopes: chatScopes, ExpiresIn: 60},
+			token:     helix.Token{AccessToken: "current", RefreshToken: "refresh"},
+			want:      helix.TokenExpiring,
+			refreshed: true,
+		},
+		{
+			name:  "expiring and not refreshable",
+			info:  helixtest.TokenInfo{ClientID: "client-id", Login: "bot", UserID: "1", Scopes: chatScopes, ExpiresIn: 60},
+			token: helix.Token{AccessToken: "current"},
+			want:  helix.TokenExpiring,
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := helixtest.NewServer(helixtest.WithAccessTokens("current"), helixtest.WithTokenInfo(tt.info))
+			defer fake.Close()
+
+			client := helix.New(newConfig(fake), helix.WithIdentity(helix.IdentityBot, "1", tt.token, nil))
+
+			var events []helix.ValidationEvent
+			validator := helix.NewValidator(client,
+				helix.WithValidatedIdentity(helix.IdentityBot),
+				helix.WithRequiredScopes(chatScopes...),
+				helix.WithOnValidation(func(event helix.ValidationEvent) { events = append(events, event) }),
+			)
+			event := validator.Check(context.Background())
+
+			if len(events) != 1 || events[0].Status != event.Status {
+				t.Fatalf("emitted %+v, want the checked event once", events)
+			}
+			if event.Status != tt.want {
+				t.Errorf("status %q, want %q", event.Status, tt.want)
+			}
+			if !slices.Equal(event.MissingScopes, tt.missing) {
+				t.Errorf("missing scopes %v, want %v", event.MissingScopes, tt.missing)
+			}
+			if (event.Err != nil) != tt.err {
+				t.Errorf("error %v, want an error: %v", event.Err, tt.err)
+			}
+			if tt.want == helix.TokenRevoked && !errors.Is(event.Err, helix.ErrUnauthorized) {
+				t.Errorf("error %v, want ErrUnauthorized", event.Err)
+			}
+			if event.Validation == nil && tt.want != helix.TokenRevoked {
+				t.Error("event without the validation")
+			}
+			if refreshed := len(fake.RequestsTo(http.MethodPost, "/oauth2/token")) > 0; refreshed != tt.refreshed {
+				t.Errorf("refreshed %v, want %v", refreshed, tt.refreshed)
+			}
+		})
+	}
+}
+
+func TestValidatorMissingIdentity(t *testing.T) {
+	fake := helixtest.NewServer()
+	defer fake.Close()
+
+	client := helix.New(newConfig(fake), helix.WithIdentity(helix.IdentityBot, "1", helix.Token{AccessToken: "bot"}, nil))
+	validator := helix.NewValidator(client, helix.WithValidatedIdentity(helix.IdentityBroadcaster))
+
+	if event := validator.Check(context.Background()); event.Status != helix.TokenMissing {
+		t.Errorf("status %q, want %q", event.Status, helix.TokenMissing)
+	}
+	// The bot's token must not be validated in the broadcaster's place
+	if requests := fake.RequestsTo(http.MethodGet, "/oauth2/validate"); len(requests) != 0 {
+		t.Errorf("sent %d validations, want none", len(requests))
+	}
+}
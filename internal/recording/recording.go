@@ -0,0 +1,178 @@
+// Package recording captures raw EventSub frames into JSONL files and replays
+// them through a dispatcher, to reproduce past sessions.
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var logger = utils.With(zap.String("component", "recording"))
+
+// maxLineSize bounds a single recorded frame; Twitch frames are far smaller
+const maxLineSize = 1 << 20
+
+// Entry is a line of a recording: a frame and when it was received
+type Entry struct {
+	ReceivedAt time.Time       `json:"received_at"`
+	Frame      json.RawMessage `json:"frame"`
+}
+
+// Recorder appends frames to a JSONL file. It is safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewRecorder creates a recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Create creates or appends to the recording file at path
+func Create(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recording: %w", err)
+	}
+	return &Recorder{w: file, closer: file}, nil
+}
+
+// Record writes a frame received now. Frames that are not valid JSON are
+// skipped, so a single bad frame does not corrupt the file.
+func (r *Recorder) Record(frame []byte) {
+	if !json.Valid(frame) {
+		logger.Warn("not recording invalid frame", zap.Int("size", len(frame)))
+		return
+	}
+
+	line, err := json.Marshal(Entry{ReceivedAt: time.Now().UTC(), Frame: frame})
+	if err != nil {
+		logger.Error("error encoding frame", zap.Error(err))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		logger.Error("error recording frame", zap.Error(err))
+	}
+}
+
+// Close closes the file opened by Create
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Read parses every entry of a recording
+func Read(reader io.Reader) ([]Entry, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	var entries []Entry
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error parsing recording line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading recording: %w", err)
+	}
+	return entries, nil
+}
+
+// ReadFile parses every entry of the recording file at path
+func ReadFile(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recording: %w", err)
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Speed sets how fast a recording is replayed relative to how it was
+// received: 1 is real time, 10 is ten times faster, 0 replays instantly
+type Speed float64
+
+const (
+	Instant  Speed = 0
+	RealTime Speed = 1
+)
+
+// ReplayStats reports what a replay did
+type ReplayStats struct {
+	Frames  int
+	Handled int
+	Skipped int
+	Errors  int
+}
+
+// Replay decodes the recorded frames and dispatches them in order, waiting
+// between frames as the speed dictates. Frames are not deduplicated or
+// checked for age, since every recorded timestamp is in the past. Handler
+// errors are logged and counted; Replay only stops early when ctx is done.
+func Replay(ctx context.Context, entries []Entry, speed Speed, dispatcher *eventsub.Dispatcher) (ReplayStats, error) {
+	var stats ReplayStats
+	for i, entry := range entries {
+		if i > 0 && speed > 0 {
+			delay := time.Duration(float64(entry.ReceivedAt.Sub(entries[i-1].ReceivedAt)) / float64(speed))
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return stats, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		stats.Frames++
+		msg, err := eventsub.Decode(entry.Frame)
+		if err != nil {
+			logger.Warn("skipping frame", zap.Int("index", i), zap.Error(err))
+			stats.Skipped++
+			continue
+		}
+
+		handled, err := dispatcher.DispatchMessage(ctx, msg)
+		if handled {
+			stats.Handled++
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("error handling replayed frame",
+				zap.Int("index", i),
+				zap.String("message_id", msg.Metadata.MessageID),
+				zap.Error(err),
+			)
+			stats.Errors++
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,156 @@
+package recording_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/recording"
+)
+
+// chatFrame is a chat notification frame as Twitch sent it long ago
+func chatFrame(messageID, text string) []byte {
+	return fmt.Appendf(nil, `{
+		"metadata": {
+			"message_id": %[1]q,
+			"message_type": "notification",
+			"message_timestamp": "2024-01-01T00:00:00Z",
+			"subscription_type": "channel.chat.message",
+			"subscription_version": "1"
+		},
+		"payload": {
+			"subscription": {"id": "subscription", "type": "channel.chat.message", "version": "1", "status": "enabled"},
+			"event": {"broadcaster_user_id": "2", "chatter_user_id": "3", "message_id": %[1]q, "message": {"text": %[2]q}}
+		}
+	}`, messageID, text)
+}
+
+// chatDispatcher collects the text of every chat message it handles
+func chatDispatcher(handled *[]string) *eventsub.Dispatcher {
+	dispatcher := eventsub.NewDispatcher()
+	dispatcher.Add(eventsub.NotificationRoute(eventsub.SubscriptionChannelChatMessage), eventsub.EventHandler(
+		func(ctx context.Context, _ *eventsub.Message, event *eventsub.ChannelChatMessage) error {
+			*handled = append(*handled, event.Message.Text)
+			return nil
+		},
+	))
+	return dispatcher
+}
+
+func TestRecordAndRead(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := recording.NewRecorder(&buf)
+
+	before := time.Now()
+	recorder.Record(chatFrame("1", "first"))
+	recorder.Record([]byte("not json"))
+	recorder.Record(chatFrame("2", "second"))
+
+	entries, err := recording.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("read %d entries, want the 2 valid frames", len(entries))
+	}
+	for i, want := range [][]byte{chatFrame("1", "first"), chatFrame("2", "second")} {
+		var compact bytes.Buffer
+		json.Compact(&compact, want)
+		if !bytes.Equal(entries[i].Frame, compact.Bytes()) {
+			t.Errorf("entry %d frame %s, want %s", i, entries[i].Frame, want)
+		}
+		if entries[i].ReceivedAt.Before(before.Add(-time.Second)) || entries[i].ReceivedAt.After(time.Now()) {
+			t.Errorf("entry %d received at %v, want about now", i, entries[i].ReceivedAt)
+		}
+	}
+
+	if _, err := recording.Read(bytes.NewBufferString("{}\nnot json\n")); err == nil {
+		t.Error("read a corrupt recording without an error")
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []recording.Entry{
+		{ReceivedAt: start, Frame: chatFrame("1", "first")},
+		{ReceivedAt: start.Add(time.Second), Frame: chatFrame("2", "second")},
+		{ReceivedAt: start.Add(2 * time.Second), Frame: chatFrame("3", "third")},
+	}
+
+	tests := []struct {
+		speed    recording.Speed
+		min, max time.Duration
+	}{
+		{recording.Instant, 0, 100 * time.Millisecond},
+		{recording.Speed(10), 200 * time.Millisecond, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.speed), func(t *testing.T) {
+			var handled []string
+			began := time.Now()
+			stats, err := recording.Replay(context.Background(), entries, tt.speed, chatDispatcher(&handled))
+			elapsed := time.Since(began)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("replay took %v, want between %v and %v", elapsed, tt.min, tt.max)
+			}
+			if stats != (recording.ReplayStats{Frames: 3, Handled: 3}) {
+				t.Errorf("stats %+v", stats)
+			}
+			if fmt.Sprint(handled) != "[first second third]" {
+				t.Errorf("handled %v, want the frames in order", handled)
+			}
+		})
+	}
+}
+
+func TestReplayStopsWithContext(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []recording.Entry{
+		{ReceivedAt: start, Frame: chatFrame("1", "first")},
+		{ReceivedAt: start.Add(time.Hour), Frame: chatFrame("2", "an hour later")},
+	}
+
+	var handled []string
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stats, err := recording.Replay(ctx, entries, recording.RealTime, chatDispatcher(&handled))
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	if stats.Frames != 1 || len(handled) != 1 {
+		t.Errorf("stats %+v, handled %v, want only the first frame", stats, handled)
+	}
+}
+
+func TestReplayDoesNotDeduplicate(t *testing.T) {
+	// A redelivered message and a frame too old to be accepted live are
+	// both replayed as recorded
+	entries := []recording.Entry{
+		{Frame: chatFrame("1", "first")},
+		{Frame: chatFrame("1", "first")},
+		{Frame: []byte(`{"metadata": {"message_type": "unknown"}}`)},
+	}
+
+	var handled []string
+	stats, err := recording.Replay(context.Background(), entries, recording.Instant, chatDispatcher(&handled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 2 {
+		t.Errorf("handled %v, want the message twice", handled)
+	}
+	if stats != (recording.ReplayStats{Frames: 3, Handled: 2, Skipped: 1}) {
+		t.Errorf("stats %+v", stats)
+	}
+}
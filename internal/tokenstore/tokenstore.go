@@ -0,0 +1,186 @@
+// Package tokenstore keeps the users' OAuth tokens between runs, so the bot
+// can start without a browser login.
+package tokenstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+)
+
+// KeySize is the length of the AES-256 key encrypting a FileStore
+const KeySize = 32
+
+var (
+	// ErrNotFound is returned by Load when no token is stored for the user
+	ErrNotFound = errors.New("no token stored for user")
+	// ErrInvalidKey is returned for a key that is not KeySize bytes
+	ErrInvalidKey = errors.New("token store key must be 32 bytes")
+)
+
+// Store holds tokens keyed by Twitch user id
+type Store interface {
+	Load(userID string) (helix.Token, error)
+	Save(userID string, token helix.Token) error
+	Delete(userID string) error
+}
+
+// ParseKey decodes a base64 encoded key, e.g. from TOKEN_STORE_KEY. A key can
+// be generated with: openssl rand -base64 32
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding token store key: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// FileStore keeps all tokens in a single file encrypted with AES-GCM. Every
+// change rewrites the file, which is replaced atomically. It is safe for
+// concurrent use.
+type FileStore struct {
+	path string
+	aead cipher.AEAD
+
+	mu sync.Mutex
+}
+
+// NewFileStore creates a store in the file at path, which is created on the
+// first Save
+func NewFileStore(path string, key []byte) (*FileStore, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+
+	return &FileStore{path: path, aead: aead}, nil
+}
+
+// Load returns the token stored for a user
+func (s *FileStore) Load(userID string) (helix.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return helix.Token{}, err
+	}
+
+	token, ok := tokens[userID]
+	if !ok {
+		return helix.Token{}, ErrNotFound
+	}
+	return token, nil
+}
+
+// Save stores a user's token, replacing the previous one
+func (s *FileStore) Save(userID string, token helix.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	tokens[userID] = token
+	return s.write(tokens)
+}
+
+// Delete removes a user's token, e.g. after Twitch revoked it
+func (s *FileStore) Delete(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := tokens[userID]; !ok {
+		return nil
+	}
+
+	delete(tokens, userID)
+	return s.write(tokens)
+}
+
+// read decrypts the file. A missing file holds no tokens. Callers must hold s.mu.
+func (s *FileStore) read() (map[string]helix.Token, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]helix.Token), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading token store: %w", err)
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("token store %s is corrupted", s.path)
+	}
+
+	plaintext, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting token store, check the key: %w", err)
+	}
+
+	tokens := make(map[string]helix.Token)
+	if err := json.Unmarshal(plaintext, &tokens); err != nil {
+		return nil, fmt.Errorf("error parsing token store: %w", err)
+	}
+	return tokens, nil
+}
+
+// write encrypts the tokens with a fresh nonce into a temporary file that
+// then replaces the store. Callers must hold s.mu.
+func (s *FileStore) write(tokens map[string]helix.Token) error {
+	plaintext, err := json.Marshal(tokens)
+	if err != nil {
+		return fmt.Errorf("error marshaling tokens: %w", err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("error generating nonce: %w", err)
+	}
+	data := s.aead.Seal(nonce, nonce, plaintext, nil)
+
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing token store: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing token store: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing token store: %w", err)
+	}
+
+	if err := os.Rename(file.Name(), s.path); err != nil {
+		return fmt.Errorf("error replacing token store: %w", err)
+	}
+	return nil
+}
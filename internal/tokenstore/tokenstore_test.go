@@ -0,0 +1,134 @@
+package tokenstore_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/tokenstore"
+)
+
+func newStore(t *testing.T, path string, key []byte) *tokenstore.FileStore {
+	t.Helper()
+	store, err := tokenstore.NewFileStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	key := bytes.Repeat([]byte{1}, tokenstore.KeySize)
+	token := helix.Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		Scopes:       []string{"user:read:chat", "user:write:chat"},
+		ExpiresAt:    time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	if err := newStore(t, path, key).Save("1", token); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new store, as after a restart, reads what the previous one saved
+	got, err := newStore(t, path, key).Load("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AccessToken != token.AccessToken || got.RefreshToken != token.RefreshToken ||
+		!slices.Equal(got.Scopes, token.Scopes) || !got.ExpiresAt.Equal(token.ExpiresAt) {
+		t.Errorf("loaded %+v, want %+v", got, token)
+	}
+
+	if _, err := newStore(t, path, key).Load("2"); !errors.Is(err, tokenstore.ErrNotFound) {
+		t.Errorf("other user: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestFileStoreIsEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	store := newStore(t, path, bytes.Repeat([]byte{1}, tokenstore.KeySize))
+
+	if err := store.Save("1", helix.Token{AccessToken: "secret-access-token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret-access-token")) {
+		t.Error("token stored in plain text")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("file mode %o, want 600", perm)
+	}
+}
+
+func TestFileStoreWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := newStore(t, path, bytes.Repeat([]byte{1}, tokenstore.KeySize)).Save("1", helix.Token{AccessToken: "access"}); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := newStore(t, path, bytes.Repeat([]byte{2}, tokenstore.KeySize))
+	if _, err := wrong.Load("1"); err == nil || errors.Is(err, tokenstore.ErrNotFound) {
+		t.Errorf("load with the wrong key: got %v, want a decryption error", err)
+	}
+	// Saving must not overwrite tokens it could not read
+	if err := wrong.Save("2", helix.Token{AccessToken: "other"}); err == nil {
+		t.Error("save with the wrong key succeeded")
+	}
+}
+
+func TestFileStoreDelete(t *testing.T) {
+	store := newStore(t, filepath.Join(t.TempDir(), "tokens"), bytes.Repeat([]byte{1}, tokenstore.KeySize))
+
+	// Nothing stored yet
+	if err := store.Delete("1"); err != nil {
+		t.Errorf("delete from an empty store: %v", err)
+	}
+
+	store.Save("1", helix.Token{AccessToken: "bot"})
+	store.Save("2", helix.Token{AccessToken: "broadcaster"})
+	if err := store.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Load("1"); !errors.Is(err, tokenstore.ErrNotFound) {
+		t.Errorf("deleted user: got %v, want ErrNotFound", err)
+	}
+	if token, err := store.Load("2"); err != nil || token.AccessToken != "broadcaster" {
+		t.Errorf("other user: got %+v, %v", token, err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, tokenstore.KeySize)
+
+	got, err := tokenstore.ParseKey(base64.StdEncoding.EncodeToString(key))
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("valid key: got %v, %v", got, err)
+	}
+	if _, err := tokenstore.ParseKey(base64.StdEncoding.EncodeToString(key[:16])); !errors.Is(err, tokenstore.ErrInvalidKey) {
+		t.Errorf("short key: got %v, want ErrInvalidKey", err)
+	}
+	if _, err := tokenstore.ParseKey("not base64!"); err == nil {
+		t.Error("invalid base64 accepted")
+	}
+	if _, err := tokenstore.NewFileStore("tokens", key[:16]); !errors.Is(err, tokenstore.ErrInvalidKey) {
+		t.Errorf("NewFileStore with a short key: got %v, want ErrInvalidKey", err)
+	}
+}
@@ -0,0 +1,329 @@
+// Package webhook implements the EventSub webhook transport: it creates
+// webhook subscriptions and receives their callbacks on the Echo server.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var logger = utils.With(zap.String("component", "webhook"))
+
+// Headers Twitch sends with every webhook callback
+const (
+	HeaderMessageID           = "Twitch-Eventsub-Message-Id"
+	HeaderMessageRetry        = "Twitch-Eventsub-Message-Retry"
+	HeaderMessageType         = "Twitch-Eventsub-Message-Type"
+	HeaderMessageSignature    = "Twitch-Eventsub-Message-Signature"
+	HeaderMessageTimestamp    = "Twitch-Eventsub-Message-Timestamp"
+	HeaderSubscriptionType    = "Twitch-Eventsub-Subscription-Type"
+	HeaderSubscriptionVersion = "Twitch-Eventsub-Subscription-Version"
+)
+
+// CallbackPath is the route webhook callbacks are received on
+const CallbackPath = "/eventsub/callback"
+
+// MaxBodySize bounds the body of a callback, which is read before its
+// signature can be checked. Twitch payloads are a few KB.
+const MaxBodySize = 512 << 10
+
+// ErrInvalidSignature is returned for callbacks whose signature does not match the secret
+var ErrInvalidSignature = errors.New("invalid eventsub message signature")
+
+// ErrInvalidSecret is returned for a secret that is not between 10 and 100
+// characters long, as Twitch requires
+var ErrInvalidSecret = errors.New("webhook secret must be 10 to 100 characters long")
+
+// Handler receives EventSub webhook callbacks and feeds their notifications
+// into a dispatcher, the same one the websocket client uses
+type Handler struct {
+	appConfig       *config.Config
+	secret          []byte
+	dispatcher      *eventsub.Dispatcher
+	dedup           *eventsub.Deduplicator
+	processorConfig eventsub.ProcessorConfig
+	processor       *eventsub.Processor
+	stopped         atomic.Bool
+	onLifecycleFunc func(eventsub.LifecycleEvent)
+}
+
+// Option defines functional options for configuring the Handler
+type Option func(*Handler)
+
+// WithOnLifecycle sets a function to be called whenever a webhook
+// subscription is created, fails to be created or is revoked by Twitch
+func WithOnLifecycle(fn func(eventsub.LifecycleEvent)) Option {
+	return func(h *Handler) {
+		h.onLifecycleFunc = fn
+	}
+}
+
+// WithProcessor sets how verified messages are queued and handed to handlers
+func WithProcessor(config eventsub.ProcessorConfig) Option {
+	return func(h *Handler) {
+		h.processorConfig = config
+	}
+}
+
+// New creates a webhook handler that verifies callbacks with the configured
+// secret and dispatches notifications to the dispatcher. It returns
+// ErrInvalidSecret when WEBHOOK_SECRET is not a valid secret.
+func New(appConfig *config.Config, dispatcher *eventsub.Dispatcher, options ...Option) (*Handler, error) {
+	secret := []byte(appConfig.WebhookSecret)
+	if !validSecret(secret) {
+		return nil, ErrInvalidSecret
+	}
+
+	handler := &Handler{
+		appConfig:       appConfig,
+		secret:          secret,
+		dispatcher:      dispatcher,
+		dedup:           eventsub.NewDeduplicator(eventsub.DefaultDedupCapacity, eventsub.DefaultDedupWindow, eventsub.DefaultDedupWindow),
+		processorConfig: eventsub.DefaultProcessorConfig,
+	}
+
+	for _, option := range options {
+		option(handler)
+	}
+
+	handler.processor = eventsub.NewProcessor(handler.processorConfig, handler.handleMessage)
+
+	return handler, nil
+}
+
+// Register adds the callback route to the Echo server. Without a valid secret
+// anyone could forge callbacks, so the route is not added.
+func (h *Handler) Register(e *echo.Echo) {
+	if !validSecret(h.secret) {
+		logger.Error("not receiving webhook callbacks", zap.Error(ErrInvalidSecret))
+		return
+	}
+	e.POST(CallbackPath, h.HandleCallback)
+}
+
+// Stop stops accepting messages and waits for the queued ones to be handled.
+// Later callbacks are answered with 503, so Twitch delivers them again.
+func (h *Handler) Stop() {
+	h.stopped.Store(true)
+	h.processor.Stop()
+}
+
+// ProcessorStats returns a snapshot of the message queues
+func (h *Handler) ProcessorStats() eventsub.ProcessorStats {
+	return h.processor.Stats()
+}
+
+type callbackBody struct {
+	Subscription eventsub.Subscription `json:"subscription"`
+	Challenge    string                `json:"challenge"`
+	Event        json.RawMessage       `json:"event"`
+}
+
+// HandleCallback verifies the signature of a callback and answers it as
+// Twitch expects: challenges are echoed back, notifications and revocations
+// are acknowledged before they are handled
+func (h *Handler) HandleCallback(c echo.Context) error {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, MaxBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		logger.Warn("rejected webhook callback", zap.Int64("max_body_size", tooLarge.Limit))
+		return c.NoContent(http.StatusRequestEntityTooLarge)
+	}
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	header := c.Request().Header
+	if err := VerifySignature(h.secret, header, body); err != nil {
+		logger.Warn("rejected webhook callback", zap.Error(err))
+		return c.NoContent(http.StatusForbidden)
+	}
+
+	var payload callbackBody
+	if err := json.Unmarshal(body, &payload); err != nil {
+		logger.Error("error parsing webhook callback", zap.Error(err))
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	timestamp, _ := time.Parse(time.RFC3339Nano, header.Get(HeaderMessageTimestamp))
+	msg := &eventsub.Message{
+		Metadata: eventsub.Metadata{
+			MessageID:           header.Get(HeaderMessageID),
+			MessageType:         eventsub.MessageType(header.Get(HeaderMessageType)),
+			MessageTimestamp:    timestamp,
+			SubscriptionType:    header.Get(HeaderSubscriptionType),
+			SubscriptionVersion: header.Get(HeaderSubscriptionVersion),
+		},
+		Raw: body,
+	}
+
+	switch msg.Metadata.MessageType {
+	case eventsub.MessageTypeWebhookCallbackVerification:
+		logger.Info("webhook callback verified",
+			zap.String("type", payload.Subscription.Type),
+			zap.String("subscription_id", payload.Subscription.ID),
+		)
+		return c.String(http.StatusOK, payload.Challenge)
+
+	case eventsub.MessageTypeNotification:
+		notification, err := eventsub.NewNotification(payload.Subscription, payload.Event)
+		if err != nil {
+			logger.Error("error parsing webhook notification", zap.Error(err))
+			return c.NoContent(http.StatusBadRequest)
+		}
+		msg.Notification = notification
+
+	case eventsub.MessageTypeRevocation:
+		msg.Revocation = &eventsub.Revocation{Subscription: payload.Subscription}
+
+	default:
+		logger.Warn("unknown webhook message type", zap.String("type", string(msg.Metadata.MessageType)))
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if h.stopped.Load() {
+		return c.NoContent(http.StatusServiceUnavailable)
+	}
+
+	// Redeliveries are acknowledged too, otherwise Twitch keeps retrying
+	if err := h.dedup.Check(msg.Metadata); err != nil {
+		logger.Debug("dropping webhook message",
+			zap.String("message_id", msg.Metadata.MessageID),
+			zap.String("retry", header.Get(HeaderMessageRetry)),
+			zap.Error(err),
+		)
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	// Twitch expects an answer within a few seconds, so handlers run after
+	// responding, on the same bounded queues as websocket messages
+	h.processor.Submit(msg)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) handleMessage(msg *eventsub.Message) {
+	if msg.Revocation != nil {
+		logger.Warn("webhook subscription revoked",
+			zap.String("type", msg.Revocation.Subscription.Type),
+			zap.String("subscription_id", msg.Revocation.Subscription.ID),
+			zap.String("reason", msg.Revocation.Reason()),
+		)
+		h.emitLifecycle(eventsub.LifecycleEvent{
+			Type: eventsub.LifecycleRevoked,
+			Request: eventsub.SubscriptionRequest{
+				Type:      msg.Revocation.Subscription.Type,
+				Version:   msg.Revocation.Subscription.Version,
+				Condition: msg.Revocation.Subscription.Condition,
+			},
+			SubscriptionID: msg.Revocation.Subscription.ID,
+			Reason:         msg.Revocation.Reason(),
+		})
+	}
+
+	if _, err := h.dispatcher.DispatchMessage(context.Background(), msg); err != nil {
+		logger.Error("error handling webhook message",
+			zap.String("message_type", string(msg.Metadata.MessageType)),
+			zap.Error(err),
+		)
+	}
+}
+
+// VerifySignature checks the Twitch-Eventsub-Message-Signature header, an
+// HMAC-SHA256 of the message id, timestamp and body keyed with the secret.
+// An invalid secret rejects every message.
+func VerifySignature(secret []byte, header http.Header, body []byte) error {
+	if !validSecret(secret) {
+		return ErrInvalidSecret
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(header.Get(HeaderMessageID)))
+	mac.Write([]byte(header.Get(HeaderMessageTimestamp)))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(header.Get(HeaderMessageSignature))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Subscribe creates webhook subscriptions pointing at the configured callback
+// URL. Webhook subscriptions outlive the process, so one that already exists
+// counts as success. It needs an app access token, see helix.NewAppClient.
+func (h *Handler) Subscribe(ctx context.Context, appHelix *helix.Client, requests []eventsub.SubscriptionRequest) error {
+	if !validSecret(h.secret) {
+		return ErrInvalidSecret
+	}
+
+	transport := eventsub.Transport{
+		Method:   "webhook",
+		Callback: h.appConfig.WebhookCallbackUrl,
+		Secret:   string(h.secret),
+	}
+
+	var errs []error
+	for _, request := range requests {
+		subscription, err := appHelix.CreateEventSubSubscription(ctx, request, transport)
+		if errors.Is(err, helix.ErrConflict) {
+			logger.Info("webhook subscription already exists", zap.String("type", request.Type))
+			continue
+		}
+
+		if err != nil {
+			logger.Error("failed to create webhook subscription",
+				zap.String("type", request.Type),
+				zap.Error(err),
+			)
+			h.emitLifecycle(eventsub.LifecycleEvent{
+				Type:    eventsub.LifecycleSubscribeFailed,
+				Request: request,
+				Err:     err,
+			})
+			errs = append(errs, err)
+			continue
+		}
+
+		logger.Info("webhook subscription created",
+			zap.String("type", request.Type),
+			zap.String("subscription_id", subscription.ID),
+		)
+		h.emitLifecycle(eventsub.LifecycleEvent{
+			Type:           eventsub.LifecycleSubscribed,
+			Request:        request,
+			SubscriptionID: subscription.ID,
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// validSecret reports whether a secret has the length Twitch requires
+func validSecret(secret []byte) bool {
+	return len(secret) >= 10 && len(secret) <= 100
+}
+
+// emitLifecycle stamps the event and passes it to the lifecycle hook
+func (h *Handler) emitLifecycle(event eventsub.LifecycleEvent) {
+	if h.onLifecycleFunc == nil {
+		return
+	}
+	event.At = time.Now()
+	h.onLifecycleFunc(event)
+}
@@ -0,0 +1,214 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/webhook"
+	"github.com/labstack/echo/v4"
+)
+
+const secret = "a-secret-of-enough-length"
+
+const chatNotification = `{
+	"subscription": {"id": "sub-1", "type": "channel.chat.message", "version": "1", "status": "enabled",
+		"condition": {"broadcaster_user_id": "2", "user_id": "1"}},
+	"event": {"broadcaster_user_id": "2", "chatter_user_id": "3", "chatter_user_login": "viewer",
+		"message_id": "chat-1", "message": {"text": "hello"}}
+}`
+
+func sign(key, id, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id + timestamp + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func signedHeader(key, id, messageType, body string) http.Header {
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	header := http.Header{}
+	header.Set(webhook.HeaderMessageID, id)
+	header.Set(webhook.HeaderMessageTimestamp, timestamp)
+	header.Set(webhook.HeaderMessageType, messageType)
+	header.Set(webhook.HeaderSubscriptionType, "channel.chat.message")
+	header.Set(webhook.HeaderSubscriptionVersion, "1")
+	header.Set(webhook.HeaderMessageSignature, sign(key, id, timestamp, body))
+	return header
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(chatNotification)
+	header := signedHeader(secret, "message-1", "notification", chatNotification)
+
+	if err := webhook.VerifySignature([]byte(secret), header, body); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+	if err := webhook.VerifySignature([]byte(secret), header, []byte(strings.Replace(chatNotification, "hello", "hacked", 1))); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("tampered body: got %v, want ErrInvalidSignature", err)
+	}
+	if err := webhook.VerifySignature([]byte("another-secret-entirely"), header, body); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("other secret: got %v, want ErrInvalidSignature", err)
+	}
+
+	forged := signedHeader("", "message-1", "notification", chatNotification)
+	if err := webhook.VerifySignature(nil, forged, body); !errors.Is(err, webhook.ErrInvalidSecret) {
+		t.Errorf("empty secret: got %v, want ErrInvalidSecret", err)
+	}
+}
+
+func TestNewRejectsInvalidSecret(t *testing.T) {
+	for _, secret := range []string{"", "too-short", strings.Repeat("x", 101)} {
+		if _, err := webhook.New(&config.Config{WebhookSecret: secret}, eventsub.NewDispatcher()); !errors.Is(err, webhook.ErrInvalidSecret) {
+			t.Errorf("secret of %d characters: got %v, want ErrInvalidSecret", len(secret), err)
+		}
+	}
+}
+
+// server serves a webhook handler whose chat notifications are recorded
+type server struct {
+	echo    *echo.Echo
+	handler *webhook.Handler
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+
+	s := &server{echo: echo.New()}
+	dispatcher := eventsub.NewDispatcher()
+	dispatcher.Add(eventsub.NotificationRoute(eventsub.SubscriptionChannelChatMessage), eventsub.EventHandler(
+		func(ctx context.Context, _ *eventsub.Message, event *eventsub.ChannelChatMessage) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.messages = append(s.messages, event.Message.Text)
+			return nil
+		},
+	))
+
+	handler, err := webhook.New(&config.Config{WebhookSecret: secret}, dispatcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Register(s.echo)
+	s.handler = handler
+	return s
+}
+
+func (s *server) post(header http.Header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, webhook.CallbackPath, strings.NewReader(body))
+	req.Header = header
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+// handled stops the handler, so every accepted message was handled
+func (s *server) handled() []string {
+	s.handler.Stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages
+}
+
+func TestCallbackVerification(t *testing.T) {
+	s := newServer(t)
+	body := `{"subscription": {"id": "sub-1", "type": "channel.chat.message", "version": "1"}, "challenge": "pogchamp-kappa-360noscope"}`
+
+	rec := s.post(signedHeader(secret, "message-1", "webhook_callback_verification", body), body)
+	if rec.Code != http.StatusOK || rec.Body.String() != "pogchamp-kappa-360noscope" {
+		t.Errorf("got %d %q, want the challenge echoed", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCallbackNotification(t *testing.T) {
+	s := newServer(t)
+	header := signedHeader(secret, "message-1", "notification", chatNotification)
+
+	if rec := s.post(header, chatNotification); rec.Code != http.StatusNoContent {
+		t.Errorf("notification: got %d, want 204", rec.Code)
+	}
+	// Twitch redelivers when it missed the answer; it is acknowledged but not handled again
+	if rec := s.post(header, chatNotification); rec.Code != http.StatusNoContent {
+		t.Errorf("redelivery: got %d, want 204", rec.Code)
+	}
+
+	if got := s.handled(); len(got) != 1 || got[0] != "hello" {
+		t.Errorf("handled %q, want one hello", got)
+	}
+}
+
+func TestCallbackRejectsForgedSignature(t *testing.T) {
+	s := newServer(t)
+
+	forged := signedHeader("", "message-1", "notification", chatNotification)
+	if rec := s.post(forged, chatNotification); rec.Code != http.StatusForbidden {
+		t.Errorf("got %d, want 403", rec.Code)
+	}
+	if got := s.handled(); len(got) != 0 {
+		t.Errorf("handled forged messages %q", got)
+	}
+}
+
+func TestCallbackRejectsOversizedBody(t *testing.T) {
+	s := newServer(t)
+
+	// Signed with the secret, the size alone gets it rejected
+	body := `{"padding": "` + strings.Repeat("x", webhook.MaxBodySize) + `"}`
+	if rec := s.post(signedHeader(secret, "message-1", "notification", body), body); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d, want 413", rec.Code)
+	}
+}
+
+func TestCallbackAfterStop(t *testing.T) {
+	s := newServer(t)
+	s.handler.Stop()
+
+	rec := s.post(signedHeader(secret, "message-1", "notification", chatNotification), chatNotification)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503 so Twitch delivers it again", rec.Code)
+	}
+}
+
+func TestCallbackRevocation(t *testing.T) {
+	events := make(chan eventsub.LifecycleEvent, 1)
+	handler, err := webhook.New(&config.Config{WebhookSecret: secret}, eventsub.NewDispatcher(),
+		webhook.WithOnLifecycle(func(event eventsub.LifecycleEvent) { events <- event }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	handler.Register(e)
+
+	body := `{"subscription": {"id": "sub-1", "type": "channel.follow", "version": "2", "status": "authorization_revoked",
+		"condition": {"broadcaster_user_id": "2", "moderator_user_id": "1"}}}`
+	req := httptest.NewRequest(http.MethodPost, webhook.CallbackPath, strings.NewReader(body))
+	req.Header = signedHeader(secret, "message-1", "revocation", body)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	handler.Stop()
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("got %d, want 204", rec.Code)
+	}
+	select {
+	case event := <-events:
+		if event.Type != eventsub.LifecycleRevoked || event.SubscriptionID != "sub-1" || event.Reason != eventsub.RevocationAuthorizationRevoked {
+			t.Errorf("lifecycle event %+v", event)
+		}
+	default:
+		t.Error("no lifecycle event for the revocation")
+	}
+}
@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// BackoffPolicy decides how long to wait before each reconnect attempt
+type BackoffPolicy interface {
+	// Next returns the delay before the given attempt, counting from 1. It
+	// returns false once no more attempts should be made.
+	Next(attempt int) (time.Duration, bool)
+}
+
+// ConstantBackoff waits the same delay before every attempt
+type ConstantBackoff struct {
+	Delay       time.Duration
+	MaxAttempts int // 0 means unlimited
+}
+
+// Next implements BackoffPolicy
+func (b ConstantBackoff) Next(attempt int) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+		return 0, false
+	}
+	return b.Delay, true
+}
+
+// ExponentialBackoff multiplies the delay after every failed attempt up to a
+// ceiling and randomizes it so many clients do not retry in lockstep
+type ExponentialBackoff struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64 // fraction of the delay, between 0 and 1, added or removed at random
+	MaxAttempts int     // 0 means unlimited
+}
+
+// DefaultBackoff is used when no policy is configured
+var DefaultBackoff = ExponentialBackoff{
+	Initial:    time.Second,
+	Max:        2 * time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Next implements BackoffPolicy
+func (b ExponentialBackoff) Next(attempt int) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+		return 0, false
+	}
+
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		jitter := min(b.Jitter, 1)
+		delay += delay * jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay), true
+}
@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	policy := ConstantBackoff{Delay: 3 * time.Second, MaxAttempts: 2}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		delay, ok := policy.Next(attempt)
+		if !ok || delay != 3*time.Second {
+			t.Errorf("attempt %d: got %v, %v, want 3s, true", attempt, delay, ok)
+		}
+	}
+	if _, ok := policy.Next(3); ok {
+		t.Error("attempt 3: want no more attempts")
+	}
+
+	unlimited := ConstantBackoff{Delay: time.Second}
+	if _, ok := unlimited.Next(1000); !ok {
+		t.Error("unlimited policy gave up")
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	policy := ExponentialBackoff{
+		Initial:     time.Second,
+		Max:         10 * time.Second,
+		Multiplier:  2,
+		MaxAttempts: 6,
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, expected := range want {
+		delay, ok := policy.Next(i + 1)
+		if !ok || delay != expected {
+			t.Errorf("attempt %d: got %v, %v, want %v, true", i+1, delay, ok, expected)
+		}
+	}
+	if _, ok := policy.Next(len(want) + 1); ok {
+		t.Errorf("attempt %d: want no more attempts", len(want)+1)
+	}
+}
+
+func TestExponentialBackoffMultiplierBelowOne(t *testing.T) {
+	policy := ExponentialBackoff{Initial: time.Second, Multiplier: 0.5}
+
+	if delay, _ := policy.Next(5); delay != time.Second {
+		t.Errorf("got %v, want the delay to never shrink below 1s", delay)
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	policy := ExponentialBackoff{Initial: 10 * time.Second, Multiplier: 2, Jitter: 0.2}
+
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		delay, ok := policy.Next(1)
+		if !ok {
+			t.Fatal("policy gave up")
+		}
+		if delay < 8*time.Second || delay > 12*time.Second {
+			t.Fatalf("delay %v outside 10s ± 20%%", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Error("jitter did not vary the delay")
+	}
+}
@@ -0,0 +1,394 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"go.uber.org/zap"
+)
+
+// shardUpdateTimeout bounds a single shard assignment request
+const shardUpdateTimeout = 10 * time.Second
+
+// Conduit receives the notifications of a Twitch conduit over several
+// websocket clients, one per shard. Subscriptions target the conduit instead
+// of a session, so Twitch spreads their notifications over the shards and
+// keeps them when a single session goes away. Every shard dispatches to the
+// same handlers.
+type Conduit struct {
+	appConfig *config.Config
+	// helix must be authorized with an app access token
+	helix         *helix.Client
+	id            string
+	shardCount    int
+	dispatcher    *eventsub.Dispatcher
+	dedup         *eventsub.Deduplicator
+	clientOptions []ClientOption
+	shards        []*Client
+	subscriptions []eventsub.SubscriptionRequest
+	started       bool
+	mu            sync.Mutex
+	ctx           context.Context
+	cancel        context.CancelFunc
+
+	onLifecycleFunc func(eventsub.LifecycleEvent)
+}
+
+// ConduitOption defines functional options for configuring the Conduit
+type ConduitOption func(*Conduit)
+
+// WithConduitID reuses an existing conduit instead of creating a new one
+func WithConduitID(id string) ConduitOption {
+	return func(c *Conduit) {
+		c.id = id
+	}
+}
+
+// WithShardCount sets how many shards, and websocket clients, the conduit has
+func WithShardCount(count int) ConduitOption {
+	return func(c *Conduit) {
+		if count > 0 {
+			c.shardCount = count
+		}
+	}
+}
+
+// WithShardOptions sets options applied to the websocket client of every shard
+func WithShardOptions(options ...ClientOption) ConduitOption {
+	return func(c *Conduit) {
+		c.clientOptions = append(c.clientOptions, options...)
+	}
+}
+
+// WithConduitDispatcher routes the notifications of every shard through an
+// existing dispatcher
+func WithConduitDispatcher(dispatcher *eventsub.Dispatcher) ConduitOption {
+	return func(c *Conduit) {
+		c.dispatcher = dispatcher
+	}
+}
+
+// WithConduitOnLifecycle sets a function to be called whenever a conduit
+// subscription is created, fails to be created or is revoked by Twitch
+func WithConduitOnLifecycle(fn func(eventsub.LifecycleEvent)) ConduitOption {
+	return func(c *Conduit) {
+		c.onLifecycleFunc = fn
+	}
+}
+
+// NewConduit creates a conduit client. The Helix client must be authorized
+// with an app access token, see helix.NewAppClient.
+func NewConduit(appConfig *config.Config, appHelix *helix.Client, options ...ConduitOption) *Conduit {
+	ctx, cancel := context.WithCancel(context.Background())
+	// A conduit is only running between Start and Stop
+	cancel()
+
+	conduit := &Conduit{
+		appConfig:  appConfig,
+		helix:      appHelix,
+		shardCount: 1,
+		dispatcher: eventsub.NewDispatcher(),
+		dedup:      eventsub.NewDeduplicator(eventsub.DefaultDedupCapacity, eventsub.DefaultDedupWindow, eventsub.DefaultDedupWindow),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	for _, option := range options {
+		option(conduit)
+	}
+
+	return conduit
+}
+
+// Dispatcher returns the dispatcher shared by every shard, to register handlers on
+func (c *Conduit) Dispatcher() *eventsub.Dispatcher {
+	return c.dispatcher
+}
+
+// ID returns the id of the conduit once it was created or reused
+func (c *Conduit) ID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id
+}
+
+// Subscribe adds a subscription that targets the conduit. When the conduit is
+// already started the subscription is created right away.
+func (c *Conduit) Subscribe(request eventsub.SubscriptionRequest) error {
+	c.mu.Lock()
+	for _, existing := range c.subscriptions {
+		if existing.Key() == request.Key() {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	c.subscriptions = append(c.subscriptions, request)
+	started := c.started
+	c.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+	return c.createSubscription(request)
+}
+
+// Start creates or resizes the conduit, connects one websocket client per
+// shard and creates the subscriptions. Shards are assigned to their session
+// as soon as it is welcomed. A stopped conduit can be started again.
+func (c *Conduit) Start() error {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+	}
+	c.mu.Unlock()
+
+	if err := c.ensureConduit(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.shards = make([]*Client, c.shardCount)
+	for i := range c.shards {
+		c.shards[i] = c.newShardClient(i)
+	}
+	shards := append([]*Client(nil), c.shards...)
+	c.started = true
+	subscriptions := append([]eventsub.SubscriptionRequest(nil), c.subscriptions...)
+	c.mu.Unlock()
+
+	var errs []error
+	for i, shard := range shards {
+		if err := shard.Start(); err != nil {
+			errs = append(errs, fmt.Errorf("shard %d: %w", i, err))
+		}
+	}
+	if len(errs) == len(shards) {
+		return errors.Join(errs...)
+	}
+
+	// Subscriptions only need the conduit to exist, notifications are held
+	// back until a shard is enabled
+	for _, request := range subscriptions {
+		if err := c.createSubscription(request); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Stop closes the websocket client of every shard. The conduit and its
+// subscriptions are kept, so another process can take over the shards.
+func (c *Conduit) Stop() {
+	c.mu.Lock()
+	c.cancel()
+	shards := append([]*Client(nil), c.shards...)
+	c.started = false
+	c.mu.Unlock()
+
+	for _, shard := range shards {
+		shard.Stop()
+	}
+}
+
+// ShardStatus describes the websocket client serving a shard
+type ShardStatus struct {
+	ID        string
+	SessionID string
+	Connected bool
+}
+
+// Shards returns the status of every shard served by this process
+func (c *Conduit) Shards() []ShardStatus {
+	c.mu.Lock()
+	shards := append([]*Client(nil), c.shards...)
+	c.mu.Unlock()
+
+	statuses := make([]ShardStatus, 0, len(shards))
+	for i, shard := range shards {
+		shard.mu.RLock()
+		statuses = append(statuses, ShardStatus{
+			ID:        strconv.Itoa(i),
+			SessionID: shard.wsSessionId,
+			Connected: shard.connected,
+		})
+		shard.mu.RUnlock()
+	}
+	return statuses
+}
+
+// runContext returns the context of the current run, cancelled by Stop
+func (c *Conduit) runContext() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctx
+}
+
+// ensureConduit creates the conduit, or resizes the configured one to the
+// wanted number of shards
+func (c *Conduit) ensureConduit() error {
+	ctx := c.runContext()
+	id := c.ID()
+	if id == "" {
+		conduit, err := c.helix.CreateConduit(ctx, c.shardCount)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.id = conduit.ID
+		c.mu.Unlock()
+
+		logger.Info("conduit created", zap.String("conduit_id", conduit.ID), zap.Int("shards", conduit.ShardCount))
+		return nil
+	}
+
+	conduit, err := c.helix.UpdateConduit(ctx, id, c.shardCount)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("conduit updated", zap.String("conduit_id", conduit.ID), zap.Int("shards", conduit.ShardCount))
+	return nil
+}
+
+// newShardClient creates the websocket client of a shard. Its sessions are
+// assigned to the shard, and when it gives up reconnecting a fresh client
+// takes over the shard.
+func (c *Conduit) newShardClient(index int) *Client {
+	options := append([]ClientOption{}, c.clientOptions...)
+	options = append(options,
+		WithDispatcher(c.dispatcher),
+		WithOnSession(func(sessionID string) {
+			c.assignShard(index, sessionID)
+		}),
+		WithOnLifecycle(c.handleLifecycle),
+		WithOnCircuitOpen(func(attempts int, err error) {
+			c.replaceShard(index, attempts, err)
+		}),
+	)
+
+	client := New(c.appConfig, options...)
+	// A notification is delivered to a single shard, but a redelivery may
+	// arrive on another one
+	client.dedup = c.dedup
+	return client
+}
+
+// assignShard points a shard at the session of its websocket client
+func (c *Conduit) assignShard(index int, sessionID string) {
+	ctx, cancel := context.WithTimeout(c.runContext(), shardUpdateTimeout)
+	defer cancel()
+
+	shardID := strconv.Itoa(index)
+	_, err := c.helix.UpdateConduitShards(ctx, c.ID(), []helix.ConduitShard{{
+		ID: shardID,
+		Transport: eventsub.Transport{
+			Method:    "websocket",
+			SessionID: sessionID,
+		},
+	}})
+	if err != nil {
+		logger.Error("failed to assign shard",
+			zap.String("shard_id", shardID),
+			zap.String("session_id", sessionID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	logger.Info("shard assigned", zap.String("shard_id", shardID), zap.String("session_id", sessionID))
+}
+
+// replaceShard swaps the websocket client of a shard that gave up
+// reconnecting for a fresh one, which reassigns the shard once welcomed
+func (c *Conduit) replaceShard(index int, attempts int, err error) {
+	logger.Warn("shard gave up reconnecting, replacing its client",
+		zap.Int("shard", index),
+		zap.Int("attempts", attempts),
+		zap.Error(err),
+	)
+
+	c.mu.Lock()
+	if !c.started || c.ctx.Err() != nil {
+		c.mu.Unlock()
+		return
+	}
+	old := c.shards[index]
+	client := c.newShardClient(index)
+	c.shards[index] = client
+	c.mu.Unlock()
+
+	old.Stop()
+
+	if err := client.Start(); err != nil {
+		logger.Error("failed to start replacement shard client", zap.Int("shard", index), zap.Error(err))
+		// Connecting is retried by the client's own reconnect loop
+		go client.reconnect()
+	}
+}
+
+// handleLifecycle drops revoked subscriptions so they are not created again,
+// and forwards every event to the lifecycle hook
+func (c *Conduit) handleLifecycle(event eventsub.LifecycleEvent) {
+	if event.Type == eventsub.LifecycleRevoked {
+		c.mu.Lock()
+		for i, request := range c.subscriptions {
+			if request.Key() == event.Request.Key() {
+				c.subscriptions = append(c.subscriptions[:i:i], c.subscriptions[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	if c.onLifecycleFunc != nil {
+		c.onLifecycleFunc(event)
+	}
+}
+
+// createSubscription creates a single subscription targeting the conduit.
+// Conduit subscriptions outlive the process, so one that already exists
+// counts as success.
+func (c *Conduit) createSubscription(request eventsub.SubscriptionRequest) error {
+	subscription, err := c.helix.CreateEventSubSubscription(c.runContext(), request, eventsub.Transport{
+		Method:    "conduit",
+		ConduitID: c.ID(),
+	})
+	if errors.Is(err, helix.ErrConflict) {
+		logger.Info("conduit subscription already exists", zap.String("type", request.Type))
+		return nil
+	}
+
+	if err != nil {
+		logger.Error("failed to create conduit subscription",
+			zap.String("type", request.Type),
+			zap.Error(err),
+		)
+		c.handleLifecycle(eventsub.LifecycleEvent{
+			Type:    eventsub.LifecycleSubscribeFailed,
+			Request: request,
+			Err:     err,
+			At:      time.Now(),
+		})
+		return err
+	}
+
+	logger.Info("conduit subscription created",
+		zap.String("type", request.Type),
+		zap.String("subscription_id", subscription.ID),
+	)
+	c.handleLifecycle(eventsub.LifecycleEvent{
+		Type:           eventsub.LifecycleSubscribed,
+		Request:        request,
+		SubscriptionID: subscription.ID,
+		At:             time.Now(),
+	})
+	return nil
+}
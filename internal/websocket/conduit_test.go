@@ -0,0 +1,180 @@
+package websocket_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub/eventsubtest"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/websocket"
+)
+
+// conduitHarness is a two shard conduit served by a fake EventSub server
+type conduitHarness struct {
+	server  *eventsubtest.Server
+	conduit *websocket.Conduit
+	chat    chan string
+}
+
+func newConduitHarness(t *testing.T) *conduitHarness {
+	t.Helper()
+
+	h := &conduitHarness{
+		server: eventsubtest.NewServer(),
+		chat:   make(chan string, 10),
+	}
+
+	appConfig := &config.Config{
+		ClientId:             "client-id",
+		EventsubWebsocketUrl: h.server.URL(),
+		HelixBaseUrl:         h.server.HelixURL(),
+	}
+	h.conduit = websocket.NewConduit(appConfig, helix.New(appConfig, helix.WithRetries(0, time.Millisecond)),
+		websocket.WithShardCount(2),
+		websocket.WithShardOptions(websocket.WithBackoff(websocket.ConstantBackoff{Delay: 10 * time.Millisecond})),
+	)
+	h.conduit.Dispatcher().Add(eventsub.NotificationRoute(eventsub.SubscriptionChannelChatMessage), eventsub.EventHandler(
+		func(ctx context.Context, _ *eventsub.Message, event *eventsub.ChannelChatMessage) error {
+			h.chat <- event.Message.Text
+			return nil
+		},
+	))
+	h.conduit.Subscribe(eventsub.SubscriptionRequest{
+		Type:      eventsub.SubscriptionChannelChatMessage,
+		Version:   "1",
+		Condition: chatCondition,
+	})
+
+	if err := h.conduit.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.conduit.Stop()
+		h.server.Close()
+	})
+	return h
+}
+
+// shards returns the shards of the fake conduit
+func (h *conduitHarness) shards() []eventsubtest.Shard {
+	for _, conduit := range h.server.Conduits() {
+		if conduit.ID == h.conduit.ID() {
+			return conduit.Shards
+		}
+	}
+	return nil
+}
+
+// waitForShards waits until every shard is enabled on one of the sessions,
+// and returns them by shard
+func (h *conduitHarness) waitForShards(t *testing.T, sessions ...*eventsubtest.Session) []*eventsubtest.Session {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var assigned []*eventsubtest.Session
+		for _, shard := range h.shards() {
+			for _, session := range sessions {
+				if shard.Status == "enabled" && shard.Transport.SessionID == session.ID {
+					assigned = append(assigned, session)
+				}
+			}
+		}
+		if len(assigned) == len(sessions) {
+			return assigned
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("shards %+v not assigned to the %d sessions", h.shards(), len(sessions))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConduitAssignsShards(t *testing.T) {
+	h := newConduitHarness(t)
+
+	conduits := h.server.Conduits()
+	if len(conduits) != 1 || conduits[0].ID != h.conduit.ID() || len(conduits[0].Shards) != 2 {
+		t.Fatalf("server conduits %+v, want one with 2 shards", conduits)
+	}
+
+	h.waitForShards(t, waitForSession(t, h.server, 1), waitForSession(t, h.server, 2))
+
+	subscriptions := h.server.Subscriptions()
+	if len(subscriptions) != 1 ||
+		subscriptions[0].Transport.Method != "conduit" ||
+		subscriptions[0].Transport.ConduitID != h.conduit.ID() {
+		t.Fatalf("server subscriptions %+v, want one on the conduit", subscriptions)
+	}
+
+	for _, status := range h.conduit.Shards() {
+		if !status.Connected || status.SessionID == "" {
+			t.Errorf("shard %+v is not connected", status)
+		}
+	}
+}
+
+func TestConduitDeliversThroughShard(t *testing.T) {
+	h := newConduitHarness(t)
+	shards := h.waitForShards(t, waitForSession(t, h.server, 1), waitForSession(t, h.server, 2))
+
+	for i, session := range shards {
+		text := "through shard " + strconv.Itoa(i)
+		if _, err := session.SendNotification(eventsub.SubscriptionChannelChatMessage, chatEvent(text)); err != nil {
+			t.Fatal(err)
+		}
+		if got := receive(t, h.chat, "chat message"); got != text {
+			t.Errorf("handled %q, want %q", got, text)
+		}
+	}
+}
+
+func TestConduitReassignsDroppedShard(t *testing.T) {
+	h := newConduitHarness(t)
+	shards := h.waitForShards(t, waitForSession(t, h.server, 1), waitForSession(t, h.server, 2))
+
+	// The shard's client reconnects, and its new session takes over the shard
+	shards[0].Close()
+	replacement := waitForSession(t, h.server, 3)
+	h.waitForShards(t, replacement, shards[1])
+
+	// Conduit subscriptions do not depend on a single session
+	if subscriptions := h.server.Subscriptions(); len(subscriptions) != 1 || subscriptions[0].Status != "enabled" {
+		t.Fatalf("server subscriptions %+v, want one enabled", subscriptions)
+	}
+
+	if _, err := replacement.SendNotification(eventsub.SubscriptionChannelChatMessage, chatEvent("reassigned")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, h.chat, "chat message"); got != "reassigned" {
+		t.Errorf("handled %q, want reassigned", got)
+	}
+}
+
+func TestConduitRestart(t *testing.T) {
+	h := newConduitHarness(t)
+	h.waitForShards(t, waitForSession(t, h.server, 1), waitForSession(t, h.server, 2))
+	id := h.conduit.ID()
+
+	h.conduit.Stop()
+	if err := h.conduit.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The conduit is reused and its shards move to the new sessions
+	if conduits := h.server.Conduits(); len(conduits) != 1 || conduits[0].ID != id {
+		t.Fatalf("server conduits %+v, want only %s", conduits, id)
+	}
+	shards := h.waitForShards(t, waitForSession(t, h.server, 3), waitForSession(t, h.server, 4))
+
+	if _, err := shards[0].SendNotification(eventsub.SubscriptionChannelChatMessage, chatEvent("restarted")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, h.chat, "chat message"); got != "restarted" {
+		t.Errorf("handled %q, want restarted", got)
+	}
+}
@@ -0,0 +1,338 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// connection is a single EventSub websocket session. While a session_reconnect
+// hand-off is in progress the client holds two of them: the current one and
+// the pending one it is moving to.
+type connection struct {
+	conn      *websocket.Conn
+	url       string
+	sessionID string
+	// keepalive is the keepalive_timeout_seconds of the session. It is only
+	// touched by the connection's read loop.
+	keepalive time.Duration
+	// retired is set when the client closes the connection on purpose, so its
+	// read loop exits without triggering a reconnect
+	retired atomic.Bool
+}
+
+// close retires the connection and closes the underlying socket
+func (wc *connection) close() {
+	wc.retired.Store(true)
+	wc.conn.Close()
+}
+
+// Keepalive watchdog settings. Until the welcome message arrives the
+// connection has welcomeTimeout to send it; afterwards any message must
+// arrive within the session keepalive plus keepaliveGrace.
+const (
+	welcomeTimeout = 10 * time.Second
+	keepaliveGrace = 3 * time.Second
+
+	minKeepaliveTimeout = 10 * time.Second
+	maxKeepaliveTimeout = 600 * time.Second
+)
+
+// extendDeadline pushes the read deadline forward after a message was received
+func (wc *connection) extendDeadline() {
+	timeout := welcomeTimeout
+	if wc.keepalive > 0 {
+		timeout = wc.keepalive + keepaliveGrace
+	}
+	wc.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// eventsubURL returns the URL for a fresh connection, requesting a custom
+// keepalive timeout when one is configured
+func (c *Client) eventsubURL() (string, error) {
+	if c.keepaliveTimeout == 0 {
+		return c.appConfig.EventsubWebsocketUrl, nil
+	}
+
+	u, err := url.Parse(c.appConfig.EventsubWebsocketUrl)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("keepalive_timeout_seconds", strconv.Itoa(int(c.keepaliveTimeout/time.Second)))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// dial opens a websocket connection to the given URL
+func (c *Client) dial(address string) (*connection, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(c.ctx, address, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	wc := &connection{conn: conn, url: address}
+	wc.extendDeadline()
+
+	return wc, nil
+}
+
+// connect establishes a fresh WebSocket connection to the configured EventSub URL
+func (c *Client) connect() error {
+	eventsubURL, err := c.eventsubURL()
+	if err != nil {
+		return err
+	}
+
+	wc, err := c.dial(eventsubURL)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	// Stop may have been called while dialing
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		wc.close()
+		return c.ctx.Err()
+	}
+	c.conn = wc
+	c.connected = true
+	c.mu.Unlock()
+
+	if c.onConnectFunc != nil {
+		c.onConnectFunc()
+	}
+
+	go c.readPump(wc)
+
+	return nil
+}
+
+// reconnect attempts to reconnect to the WebSocket server, waiting between
+// attempts as the backoff policy dictates. The wait is cut short by Stop.
+func (c *Client) reconnect() {
+	c.mu.Lock()
+	c.connected = false
+	c.mu.Unlock()
+
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		delay, ok := c.backoff.Next(attempt)
+		if !ok {
+			logger.Error("giving up reconnecting",
+				zap.Int("attempts", attempt-1),
+				zap.Error(lastErr),
+			)
+			if c.onCircuitOpen != nil {
+				c.onCircuitOpen(attempt-1, lastErr)
+			}
+			return
+		}
+
+		logger.Info("attempting to reconnect",
+			zap.String("url", c.appConfig.EventsubWebsocketUrl),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		lastErr = c.connect()
+		if lastErr == nil {
+			logger.Info("successfully reconnected", zap.String("url", c.appConfig.EventsubWebsocketUrl))
+			return
+		}
+
+		logger.Warn("failed to reconnect", zap.Int("attempt", attempt), zap.Error(lastErr))
+	}
+}
+
+// readPump handles reading messages from one WebSocket connection
+func (c *Client) readPump(wc *connection) {
+	defer func() {
+		wc.conn.Close()
+
+		// A retired connection was replaced or stopped on purpose
+		if wc.retired.Load() {
+			return
+		}
+
+		c.mu.Lock()
+		current := c.conn == wc
+		if current {
+			c.connected = false
+			c.resetSession()
+		}
+		c.mu.Unlock()
+
+		if !current {
+			return
+		}
+
+		if c.onDisconnectFunc != nil {
+			c.onDisconnectFunc(nil)
+		}
+
+		if c.autoReconnect {
+			go c.reconnect()
+		}
+	}()
+
+	for {
+		_, message, err := wc.conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				logger.Warn("no message within keepalive timeout, connection considered dead",
+					zap.String("session_id", wc.sessionID),
+					zap.Duration("keepalive", wc.keepalive),
+				)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("read error: %v", err)
+			}
+			break
+		}
+
+		// Every message, keepalive or notification, proves the connection is alive
+		wc.extendDeadline()
+
+		if c.onFrameFunc != nil {
+			c.onFrameFunc(message)
+		}
+
+		msg, err := eventsub.Decode(message)
+		if err != nil {
+			logger.Error("error parsing message", zap.Error(err))
+			continue
+		}
+
+		// Session messages change which connection is current, so they are
+		// handled on the read loop before anything else from this socket
+		switch msg.Metadata.MessageType {
+		case eventsub.MessageTypeSessionWelcome:
+			c.handleSessionWelcome(wc, msg)
+		case eventsub.MessageTypeSessionReconnect:
+			c.handleSessionReconnect(wc, msg)
+		}
+
+		c.processor.Submit(msg)
+	}
+}
+
+// handleSessionWelcome records the session of a connection. When the
+// connection is the target of a session_reconnect hand-off it becomes the
+// current one and the old socket is closed; subscriptions carry over to the
+// new session so nothing is registered again.
+func (c *Client) handleSessionWelcome(wc *connection, msg *eventsub.Message) {
+	wc.keepalive = time.Duration(msg.Welcome.Session.KeepaliveTimeoutSeconds) * time.Second
+	wc.extendDeadline()
+
+	c.mu.Lock()
+	wc.sessionID = msg.Welcome.Session.ID
+
+	if c.pending == wc {
+		old := c.conn
+		c.conn = wc
+		c.pending = nil
+		c.wsSessionId = wc.sessionID
+		c.connected = true
+		c.mu.Unlock()
+
+		logger.Info("session reconnect completed",
+			zap.String("session_id", wc.sessionID),
+			zap.String("url", wc.url),
+		)
+
+		if old != nil {
+			old.close()
+		}
+
+		if c.onSessionFunc != nil {
+			go c.onSessionFunc(wc.sessionID)
+		}
+		return
+	}
+
+	if c.conn != wc {
+		// A stale connection that is no longer current
+		c.mu.Unlock()
+		return
+	}
+
+	// A fresh session starts without subscriptions
+	c.resetSession()
+	c.wsSessionId = wc.sessionID
+	c.mu.Unlock()
+
+	logger.Info("session welcomed", zap.String("session_id", wc.sessionID))
+
+	// Subscribing talks to Helix, which must not stall the read loop and its keepalive deadline
+	go registerEventSubListeners(c, wc.sessionID)
+
+	if c.onSessionFunc != nil {
+		go c.onSessionFunc(wc.sessionID)
+	}
+}
+
+// handleSessionReconnect dials the reconnect URL Twitch sent before
+// maintenance. The old connection keeps delivering notifications until the
+// new one receives its welcome message.
+func (c *Client) handleSessionReconnect(wc *connection, msg *eventsub.Message) {
+	reconnectURL := msg.Reconnect.Session.ReconnectURL
+	if reconnectURL == "" {
+		logger.Error("session_reconnect without reconnect_url")
+		return
+	}
+
+	c.mu.RLock()
+	current := c.conn == wc
+	c.mu.RUnlock()
+	if !current {
+		return
+	}
+
+	logger.Info("session reconnect requested", zap.String("url", reconnectURL))
+
+	go func() {
+		next, err := c.dial(reconnectURL)
+		if err != nil {
+			// The old connection is closed by Twitch shortly after, which
+			// falls back to a regular reconnect
+			logger.Error("failed to dial reconnect url", zap.Error(err))
+			return
+		}
+
+		c.mu.Lock()
+		// Stop may have been called while dialing, and it has already closed
+		// the connections it knew about
+		if c.ctx.Err() != nil {
+			c.mu.Unlock()
+			next.close()
+			return
+		}
+		if c.pending != nil {
+			c.pending.close()
+		}
+		c.pending = next
+		c.mu.Unlock()
+
+		go c.readPump(next)
+	}()
+}
@@ -0,0 +1,227 @@
+package websocket
+
+import (
+	"errors"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"go.uber.org/zap"
+)
+
+// subscription is an entry of the client's subscription registry. The id is
+// only valid for the session it was created on.
+type subscription struct {
+	request eventsub.SubscriptionRequest
+	id      string
+}
+
+// SubscriptionResult reports whether a subscription was created for a session
+type SubscriptionResult struct {
+	Request        eventsub.SubscriptionRequest
+	SessionID      string
+	SubscriptionID string
+	Err            error
+}
+
+// Subscribe adds a subscription to the registry. Every subscription in the
+// registry is created again whenever a fresh session is welcomed, so it
+// survives reconnects. When a session is already active the subscription is
+// created right away and the Helix error, if any, is returned; check it with
+// errors.Is against helix.ErrConflict, helix.ErrMissingScope and helix.ErrRateLimited.
+func (c *Client) Subscribe(subscriptionType, version string, condition map[string]string) error {
+	return c.SubscribeRequest(eventsub.SubscriptionRequest{
+		Type:      subscriptionType,
+		Version:   version,
+		Condition: condition,
+	})
+}
+
+// SubscribeRequest is Subscribe for a prepared request, such as one built
+// from the configured subscription list
+func (c *Client) SubscribeRequest(request eventsub.SubscriptionRequest) error {
+	c.mu.Lock()
+	for _, sub := range c.subscriptions {
+		if sub.request.Key() == request.Key() {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	sub := &subscription{request: request}
+	c.subscriptions = append(c.subscriptions, sub)
+	sessionID := c.wsSessionId
+	c.mu.Unlock()
+
+	if sessionID == "" {
+		return nil
+	}
+
+	id, err := createSubscription(c, request, sessionID)
+	if err != nil {
+		c.emitLifecycle(eventsub.LifecycleEvent{
+			Type:    eventsub.LifecycleSubscribeFailed,
+			Request: request,
+			Err:     err,
+		})
+		return err
+	}
+
+	c.mu.Lock()
+	if c.wsSessionId == sessionID {
+		sub.id = id
+	}
+	c.mu.Unlock()
+
+	c.emitLifecycle(eventsub.LifecycleEvent{
+		Type:           eventsub.LifecycleSubscribed,
+		Request:        request,
+		SubscriptionID: id,
+	})
+	return nil
+}
+
+// Subscriptions returns the subscriptions the client keeps alive
+func (c *Client) Subscriptions() []eventsub.SubscriptionRequest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	requests := make([]eventsub.SubscriptionRequest, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		requests = append(requests, sub.request)
+	}
+	return requests
+}
+
+// resetSession forgets the session id and the subscription ids tied to it
+// after the current connection was lost. Callers must hold c.mu.
+func (c *Client) resetSession() {
+	c.wsSessionId = ""
+	for _, sub := range c.subscriptions {
+		sub.id = ""
+	}
+}
+
+// registerEventSubListeners creates every subscription of the registry on the
+// given session and reports the outcome of each one
+func registerEventSubListeners(c *Client, sessionID string) []SubscriptionResult {
+	c.mu.RLock()
+	subscriptions := append([]*subscription(nil), c.subscriptions...)
+	c.mu.RUnlock()
+
+	results := make([]SubscriptionResult, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		id, err := createSubscription(c, sub.request, sessionID)
+
+		result := SubscriptionResult{
+			Request:        sub.request,
+			SessionID:      sessionID,
+			SubscriptionID: id,
+			Err:            err,
+		}
+		results = append(results, result)
+
+		if errors.Is(err, helix.ErrConflict) {
+			// Twitch already delivers this subscription to the session
+			logger.Warn("subscription already exists",
+				zap.String("type", sub.request.Type),
+				zap.String("version", sub.request.Version),
+			)
+			continue
+		}
+
+		if err != nil {
+			logger.Error("failed to subscribe",
+				zap.String("type", sub.request.Type),
+				zap.String("version", sub.request.Version),
+				zap.Error(err),
+			)
+			c.emitLifecycle(eventsub.LifecycleEvent{
+				Type:    eventsub.LifecycleSubscribeFailed,
+				Request: sub.request,
+				Err:     err,
+			})
+			continue
+		}
+
+		c.mu.Lock()
+		// The session may have been replaced while the request was in flight
+		if c.wsSessionId == sessionID {
+			sub.id = id
+		}
+		c.mu.Unlock()
+
+		logger.Info("subscribed",
+			zap.String("type", sub.request.Type),
+			zap.String("version", sub.request.Version),
+			zap.String("subscription_id", id),
+		)
+		c.emitLifecycle(eventsub.LifecycleEvent{
+			Type:           eventsub.LifecycleSubscribed,
+			Request:        sub.request,
+			SubscriptionID: id,
+		})
+	}
+
+	if c.onSubscribeFunc != nil {
+		c.onSubscribeFunc(results)
+	}
+
+	return results
+}
+
+// handleRevocation removes a revoked subscription from the registry so it is
+// not created again on the next session, and reports it
+func (c *Client) handleRevocation(revocation *eventsub.Revocation) {
+	revoked := revocation.Subscription
+	request := eventsub.SubscriptionRequest{
+		Type:      revoked.Type,
+		Version:   revoked.Version,
+		Condition: revoked.Condition,
+	}
+
+	c.mu.Lock()
+	for i, sub := range c.subscriptions {
+		if (sub.id != "" && sub.id == revoked.ID) || sub.request.Key() == request.Key() {
+			request = sub.request
+			c.subscriptions = append(c.subscriptions[:i:i], c.subscriptions[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	logger.Warn("subscription revoked",
+		zap.String("type", revoked.Type),
+		zap.String("version", revoked.Version),
+		zap.String("subscription_id", revoked.ID),
+		zap.String("reason", revocation.Reason()),
+	)
+
+	c.emitLifecycle(eventsub.LifecycleEvent{
+		Type:           eventsub.LifecycleRevoked,
+		Request:        request,
+		SubscriptionID: revoked.ID,
+		Reason:         revocation.Reason(),
+	})
+}
+
+// emitLifecycle stamps the event and passes it to the lifecycle hook
+func (c *Client) emitLifecycle(event eventsub.LifecycleEvent) {
+	if c.onLifecycleFunc == nil {
+		return
+	}
+	event.At = time.Now()
+	c.onLifecycleFunc(event)
+}
+
+// createSubscription creates a single websocket subscription for a session
+func createSubscription(c *Client, request eventsub.SubscriptionRequest, sessionID string) (string, error) {
+	subscription, err := c.helix.CreateEventSubSubscription(c.ctx, request, eventsub.Transport{
+		Method:    "websocket",
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return subscription.ID, nil
+}
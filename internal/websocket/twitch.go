@@ -1,23 +1,42 @@
 package websocket
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"strings"
 	"time"
 
+	"github.com/OleksandrOleniuk/twitchong/internal/chat"
 	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
 	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
 	"go.uber.org/zap"
 )
 
-func NewTwitchChat(appConfig *config.Config) *Client {
-	client := New(appConfig,
-		WithReconnectDelay(time.Second*3),
+// outboxDrainTimeout bounds how long Stop waits for queued chat messages
+const outboxDrainTimeout = 5 * time.Second
+
+func NewTwitchChat(appConfig *config.Config, options ...ClientOption) *Client {
+	options = append([]ClientOption{
+		WithBackoff(ExponentialBackoff{
+			Initial:     time.Second * 3,
+			Max:         time.Minute * 2,
+			Multiplier:  2,
+			Jitter:      0.2,
+			MaxAttempts: 30,
+		}),
+		WithOnCircuitOpen(func(attempts int, err error) {
+			logger.Error("Gave up reconnecting to WebSocket server", zap.Int("attempts", attempts), zap.Error(err))
+		}),
+		// Chat replies may wait on the LLM, so only messages from the same
+		// chatter wait for each other
+		WithProcessor(eventsub.ProcessorConfig{
+			Mode:      eventsub.ProcessByLane,
+			Workers:   4,
+			QueueSize: 256,
+			Overflow:  eventsub.OverflowDropOldest,
+			Lane:      eventsub.LaneByUser,
+		}),
 		WithOnConnect(func() {
 			logger.Info("Connected to WebSocket server!")
 		}),
@@ -28,14 +47,50 @@ func NewTwitchChat(appConfig *config.Config) *Client {
 				logger.Info("Disconnected from WebSocket server")
 			}
 		}),
-	)
 
-	client.HandleWelcome()
+		// Long LLM answers are split into numbered parts
+		WithChatQueue(chat.WithNumberedParts(true)),
+	}, options...)
+
+	// Options passed by the caller are applied last so they override the defaults
+	client := New(appConfig, options...)
+	client.SetBotLogin(appConfig.BotLogin)
+
+	client.Use(eventsub.RecoverMiddleware(), eventsub.LoggingMiddleware())
+
+	// Listen to the configured EventSub types; channel.chat.message joins the
+	// chatroom from your bot's account
+	moderator := appConfig.ModeratorUserId
+	if moderator == "" {
+		moderator = appConfig.BotUserId
+	}
+	ids := eventsub.ConditionIDs{
+		BroadcasterUserID: appConfig.ChatChannelUserId,
+		UserID:            appConfig.BotUserId,
+		ModeratorUserID:   moderator,
+	}
+	subscriptions, err := eventsub.ParseSubscriptionList(appConfig.EventsubSubscriptions, ids)
+	if err != nil {
+		logger.Warn("skipping invalid EventSub subscriptions", zap.Error(err))
+	}
+	// Without any valid entry the bot would not even hear chat
+	if len(subscriptions) == 0 {
+		logger.Error("no valid EventSub subscriptions configured, subscribing to chat messages only")
+		request, _ := eventsub.NewSubscriptionRequest(eventsub.SubscriptionChannelChatMessage, "", ids)
+		subscriptions = append(subscriptions, request)
+	}
+	for _, subscription := range subscriptions {
+		client.SubscribeRequest(subscription)
+	}
 
-	client.HandleMessage(func(text string) {
-		if strings.Contains(text, "HeyGuys") {
-			sendChatMessage(client.appConfig, "VoHiYo")
+	// Log every chat message before any reaction runs
+	client.HandleMessage(logChatMessage, eventsub.WithPriority(-1))
+
+	client.HandleMessage(func(ctx context.Context, msg *eventsub.ChannelChatMessage) error {
+		if strings.Contains(msg.Message.Text, "HeyGuys") {
+			return client.sendChatMessage("VoHiYo", chat.PriorityLow)
 		}
+		return nil
 	})
 
 	// client.HandleMessage(func(text string) {
@@ -44,105 +99,137 @@ func NewTwitchChat(appConfig *config.Config) *Client {
 	// 	}
 	// })
 
-	client.HandleMessage(func(text string) {
-		if strings.HasPrefix(text, "@WayongBotJr") {
-			prompt := strings.Split(text, "@WayongBotJr ")[1]
+	// Questions to the bot are answered by the LLM in a reply thread. Viewers
+	// replying to an answer continue the conversation with the answer as context.
+	client.HandleMessage(func(ctx context.Context, msg *eventsub.ChannelChatMessage) error {
+		if prompt, ok := questionToBot(msg, appConfig.BotUserId, client.BotLogin()); ok {
 			fmt.Printf("prompt: %v\n", prompt)
+
+			question := "My question to you is: \"" + prompt + "\"."
+			if msg.IsReply() && msg.Reply.ParentUserID == appConfig.BotUserId {
+				question = "You said: \"" + msg.Reply.ParentMessageBody + "\". " + question
+			}
+
 			config := utils.RequestConfig{
 				Method: "POST",
 				URL:    "http://localhost:11434/api/generate",
 				Body: map[string]any{
 					"model":  "gemma3:1b",
-					"prompt": "My question to you is: \"" + prompt + "\". Respond to it with maximum 50 words in Ukrainian language. Do not ask questions. Do not apologize. Do not quote yourself.",
+					"prompt": question + " Respond to it with maximum 50 words in Ukrainian language. Do not ask questions. Do not apologize. Do not quote yourself.",
 					"stream": false,
 				},
+				// A stuck model must not hold the chatter's lane forever
+				Timeout:         time.Minute,
+				MaxResponseSize: 1 << 20,
 			}
 
 			var res struct {
 				Response string `json:"response"`
 			}
 
-			err := utils.SendRequestAndParseResponse(config, &res)
+			err := utils.SendRequestAndParseResponseContext(ctx, config, &res)
 			if err != nil {
-				logger.Error("token validation failed", zap.Error(err))
-				return
+				logger.Error("ollama request failed", zap.Error(err))
+				return nil
 			}
 
 			fmt.Printf("res.Response: %v\n", res.Response)
 
-			sendChatMessage(client.appConfig, res.Response)
-
+			return client.Reply(msg, res.Response, chat.PriorityNormal)
 		}
+		return nil
 	})
 
 	// Default handler for unmatched message types
-	client.HandleDefault(func(ctx context.Context, data map[string]any) error {
+	client.HandleDefault(func(ctx context.Context, msg *eventsub.Message) error {
 		// advanced logger
 		// utils.PrettyObject("Unhandled ws message", "data", data)
 		return nil
 	})
 
-// 	ticker:=time.NewTicker(60000)
-// 	go func(c *Client)() {
-// defer ticker.Stop()
-//
-// 	}
-//
-// 	return client
+	return client
 }
 
-func StartTwitchChat(client *Client) error {
-	if err := client.Start(); err != nil {
-		logger.Error("Failed to establish WebSocket connection")
+// questionToBot returns the text of a message addressed to the bot: a reply
+// to one of its messages, or a message starting with a mention of its login.
+// Chatters type the login in any case, so it is matched ignoring case.
+// Without a known login only replies count.
+func questionToBot(msg *eventsub.ChannelChatMessage, botUserID, botLogin string) (string, bool) {
+	if msg.IsReply() && msg.Reply.ParentUserID == botUserID {
+		prompt := strings.TrimSpace(msg.TextWithoutReplyMention())
+		return prompt, prompt != ""
 	}
-	return nil
-}
-
-func sendChatMessage(appConfig *config.Config, chatMessage string) error {
-	// Prepare the request body
-	requestBody := map[string]string{
-		"broadcaster_id": appConfig.ChatChannelUserId,
-		"sender_id":      appConfig.BotUserId,
-		"message":        chatMessage,
+	if botLogin == "" {
+		return "", false
 	}
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		logger.Error("error marshaling request body", zap.Error(err))
-		return err
+	text := msg.Message.Text
+	mention := "@" + botLogin
+	if len(text) < len(mention) || !strings.EqualFold(text[:len(mention)], mention) {
+		return "", false
 	}
 
-	// Create the HTTP request
-	req, err := http.NewRequest("POST", "https://api.twitch.tv/helix/chat/messages", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		logger.Error("error creating request", zap.Error(err))
-		return err
+	// "@botlogin2" mentions someone else
+	rest := text[len(mention):]
+	if rest != "" && rest[0] != ' ' {
+		return "", false
 	}
 
-	// Add headers
-	req.Header.Set("Authorization", "Bearer "+appConfig.OauthToken)
-	req.Header.Set("Client-Id", appConfig.ClientId)
-	req.Header.Set("Content-Type", "application/json")
+	prompt := strings.TrimSpace(rest)
+	return prompt, prompt != ""
+}
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logger.Error("error sending request", zap.Error(err))
-		return err
+// logChatMessage prints a chat message to the program's console
+func logChatMessage(ctx context.Context, msg *eventsub.ChannelChatMessage) error {
+	fields := []zap.Field{
+		zap.String("channel", msg.BroadcasterUserLogin),
+		zap.String("user", msg.ChatterUserLogin),
+		zap.String("message", msg.Message.Text),
+		zap.String("message_id", msg.MessageID),
 	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		logger.Error("failed to send chat message",
-			zap.Int("status", resp.StatusCode),
-			zap.String("response", string(body)),
+	if msg.IsReply() {
+		fields = append(fields,
+			zap.String("reply_to", msg.Reply.ParentUserLogin),
+			zap.String("thread_id", msg.ThreadID()),
 		)
-		return fmt.Errorf("failed to send chat message: status code %d", resp.StatusCode)
 	}
+	logger.Info("chat message received", fields...)
 
-	logger.Info("chat message sent", zap.String("message", chatMessage))
 	return nil
 }
+
+func StartTwitchChat(client *Client) error {
+	if err := client.Start(); err != nil {
+		logger.Error("Failed to establish WebSocket connection")
+	}
+	return nil
+}
+
+// sendChatMessage queues a message to the configured channel as the bot. The
+// outcome is logged by the queue.
+func (c *Client) sendChatMessage(chatMessage string, priority chat.Priority) error {
+	return c.enqueueChatMessage(chat.Message{
+		BroadcasterID: c.appConfig.ChatChannelUserId,
+		Text:          chatMessage,
+		Priority:      priority,
+	})
+}
+
+// Reply queues a message as the bot, threaded as a reply to msg in the
+// channel it was sent to
+func (c *Client) Reply(msg *eventsub.ChannelChatMessage, text string, priority chat.Priority) error {
+	return c.enqueueChatMessage(chat.Message{
+		BroadcasterID:        msg.BroadcasterUserID,
+		Text:                 text,
+		ReplyParentMessageID: msg.MessageID,
+		Priority:             priority,
+	})
+}
+
+func (c *Client) enqueueChatMessage(msg chat.Message) error {
+	err := c.outbox.Enqueue(msg)
+	if err != nil {
+		logger.Warn("failed to queue chat message", zap.String("message", msg.Text), zap.Error(err))
+	}
+	return err
+}
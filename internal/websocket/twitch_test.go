@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+)
+
+func TestQuestionToBot(t *testing.T) {
+	botReply := &eventsub.ChatReply{ParentUserID: "1", ParentUserLogin: "wayongbotjr"}
+	otherReply := &eventsub.ChatReply{ParentUserID: "3", ParentUserLogin: "viewer"}
+
+	tests := []struct {
+		name   string
+		text   string
+		reply  *eventsub.ChatReply
+		login  string
+		prompt string
+		ok     bool
+	}{
+		{"mention", "@WayongBotJr how are you?", nil, "WayongBotJr", "how are you?", true},
+		{"mention in another case", "@wayongbotjr how are you?", nil, "WayongBotJr", "how are you?", true},
+		{"mention only", "@WayongBotJr", nil, "WayongBotJr", "", false},
+		{"longer login", "@WayongBotJr2 how are you?", nil, "WayongBotJr", "", false},
+		{"mention later", "hi @WayongBotJr", nil, "WayongBotJr", "", false},
+		{"reply to the bot", "@WayongBotJr and then?", botReply, "WayongBotJr", "and then?", true},
+		{"reply mentioning the display name", "@WAYONGBOTJR and then?", botReply, "WayongBotJr", "and then?", true},
+		{"reply to a viewer", "@viewer agreed", otherReply, "WayongBotJr", "", false},
+		{"reply to a viewer asking the bot", "@WayongBotJr is @viewer right?", otherReply, "WayongBotJr", "is @viewer right?", true},
+		// Before the bot's token was validated its login is unknown
+		{"unknown login", "@ how are you?", nil, "", "", false},
+		{"reply with unknown login", "@WayongBotJr and then?", botReply, "", "and then?", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg := &eventsub.ChannelChatMessage{
+				Message: eventsub.ChatMessageBody{Text: test.text},
+				Reply:   test.reply,
+			}
+			prompt, ok := questionToBot(msg, "1", test.login)
+			if prompt != test.prompt || ok != test.ok {
+				t.Errorf("questionToBot(%q) = %q, %v, want %q, %v", test.text, prompt, ok, test.prompt, test.ok)
+			}
+		})
+	}
+}
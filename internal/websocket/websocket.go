@@ -1,47 +1,52 @@
 package websocket
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"sync"
 	"time"
 
+	"github.com/OleksandrOleniuk/twitchong/internal/chat"
 	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
 	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
-	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
 var logger = utils.With(zap.String("component", "websocket"))
 
-// MessageType represents different types of messages that can be handled
-type MessageType string
-
-// HandlerFunc defines the function signature for message handlers
-type HandlerFunc func(ctx context.Context, data map[string]any) error
-
 // Client represents a WebSocket client connection
 type Client struct {
 	appConfig        *config.Config
-	conn             *websocket.Conn
+	helix            *helix.Client
+	outbox           *chat.Queue
+	outboxOptions    []chat.QueueOption
+	conn             *connection
+	pending          *connection
 	wsSessionId      string
-	wsSubscriptionId string
-	handlers         map[MessageType]HandlerFunc
-	defaultHandler   HandlerFunc
-	welcomeHandler   HandlerFunc
+	botLogin         string
+	subscriptions    []*subscription
+	onSubscribeFunc  func([]SubscriptionResult)
+	onLifecycleFunc  func(eventsub.LifecycleEvent)
+	dispatcher       *eventsub.Dispatcher
+	dedup            *eventsub.Deduplicator
+	processorConfig  eventsub.ProcessorConfig
+	processor        *eventsub.Processor
+	defaultHandler   eventsub.HandlerFunc
 	mu               sync.RWMutex
 	ctx              context.Context
 	cancel           context.CancelFunc
-	reconnectDelay   time.Duration
+	backoff          BackoffPolicy
+	keepaliveTimeout time.Duration
 	connected        bool
 	autoReconnect    bool
 	onConnectFunc    func()
 	onDisconnectFunc func(error)
+	onSessionFunc    func(sessionID string)
+	onFrameFunc      func(frame []byte)
+	onCircuitOpen    func(attempts int, err error)
 }
 
 // ClientOption defines functional options for configuring the Client
@@ -52,12 +57,15 @@ func New(config *config.Config, options ...ClientOption) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	client := &Client{
-		handlers:       make(map[MessageType]HandlerFunc),
-		ctx:            ctx,
-		cancel:         cancel,
-		reconnectDelay: time.Second * 5,
-		autoReconnect:  true,
-		appConfig:      config,
+		dispatcher:      eventsub.NewDispatcher(),
+		ctx:             ctx,
+		cancel:          cancel,
+		backoff:         DefaultBackoff,
+		processorConfig: eventsub.DefaultProcessorConfig,
+		dedup:           eventsub.NewDeduplicator(eventsub.DefaultDedupCapacity, eventsub.DefaultDedupWindow, eventsub.DefaultDedupWindow),
+		autoReconnect:   true,
+		appConfig:       config,
+		helix:           helix.New(config),
 	}
 
 	// Apply all options
@@ -65,13 +73,60 @@ func New(config *config.Config, options ...ClientOption) *Client {
 		option(client)
 	}
 
+	client.processor = eventsub.NewProcessor(client.processorConfig, client.handleMessage)
+
+	// Every chat message goes through one queue that keeps within the chat
+	// rate limit of the bot's role
+	client.outbox = chat.NewQueue(client.helix, config.BotUserId, append([]chat.QueueOption{
+		chat.WithRateLimit(chat.LimitForRole(config.ChatBotRole)),
+	}, client.outboxOptions...)...)
+
 	return client
 }
 
-// WithReconnectDelay sets the delay between reconnection attempts
+// WithReconnectDelay sets a constant delay between reconnection attempts
 func WithReconnectDelay(delay time.Duration) ClientOption {
 	return func(c *Client) {
-		c.reconnectDelay = delay
+		c.backoff = ConstantBackoff{Delay: delay}
+	}
+}
+
+// WithBackoff sets the policy that decides the delay before each reconnection attempt
+func WithBackoff(policy BackoffPolicy) ClientOption {
+	return func(c *Client) {
+		c.backoff = policy
+	}
+}
+
+// WithOnCircuitOpen sets a function to be called when the backoff policy gives
+// up reconnecting. It receives the number of failed attempts and the last error.
+func WithOnCircuitOpen(fn func(attempts int, err error)) ClientOption {
+	return func(c *Client) {
+		c.onCircuitOpen = fn
+	}
+}
+
+// WithKeepaliveTimeout asks Twitch for a custom keepalive timeout through the
+// keepalive_timeout_seconds query parameter. Twitch accepts 10 to 600 seconds;
+// values outside that range are clamped.
+func WithKeepaliveTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.keepaliveTimeout = min(max(timeout, minKeepaliveTimeout), maxKeepaliveTimeout)
+	}
+}
+
+// WithProcessor sets how received messages are queued and handed to handlers
+func WithProcessor(config eventsub.ProcessorConfig) ClientOption {
+	return func(c *Client) {
+		c.processorConfig = config
+	}
+}
+
+// WithDeduplication configures how many message ids are remembered, for how
+// long, and the maximum age of a message timestamp before it is rejected
+func WithDeduplication(capacity int, ttl, maxAge time.Duration) ClientOption {
+	return func(c *Client) {
+		c.dedup = eventsub.NewDeduplicator(capacity, ttl, maxAge)
 	}
 }
 
@@ -89,6 +144,61 @@ func WithOnConnect(fn func()) ClientOption {
 	}
 }
 
+// WithOnSubscribe sets a function to be called with the outcome of every
+// subscription after they were (re)created for a new session
+func WithOnSubscribe(fn func([]SubscriptionResult)) ClientOption {
+	return func(c *Client) {
+		c.onSubscribeFunc = fn
+	}
+}
+
+// WithOnLifecycle sets a function to be called whenever a subscription is
+// created, fails to be created or is revoked by Twitch
+func WithOnLifecycle(fn func(eventsub.LifecycleEvent)) ClientOption {
+	return func(c *Client) {
+		c.onLifecycleFunc = fn
+	}
+}
+
+// WithOnSession sets a function to be called whenever a session is welcomed,
+// including the new session after a session_reconnect hand-off
+func WithOnSession(fn func(sessionID string)) ClientOption {
+	return func(c *Client) {
+		c.onSessionFunc = fn
+	}
+}
+
+// WithOnFrame sets a function to be called with every raw frame as it is
+// read from the socket, before it is decoded, e.g. recording.Recorder.Record
+func WithOnFrame(fn func(frame []byte)) ClientOption {
+	return func(c *Client) {
+		c.onFrameFunc = fn
+	}
+}
+
+// WithDispatcher routes messages through an existing dispatcher, so the same
+// handlers can serve several transports
+func WithDispatcher(dispatcher *eventsub.Dispatcher) ClientOption {
+	return func(c *Client) {
+		c.dispatcher = dispatcher
+	}
+}
+
+// WithHelix sets the Helix client used for subscriptions and chat messages,
+// e.g. one sharing a refreshed user token
+func WithHelix(client *helix.Client) ClientOption {
+	return func(c *Client) {
+		c.helix = client
+	}
+}
+
+// WithChatQueue adds options for the queue chat messages are sent through
+func WithChatQueue(options ...chat.QueueOption) ClientOption {
+	return func(c *Client) {
+		c.outboxOptions = append(c.outboxOptions, options...)
+	}
+}
+
 // WithOnDisconnect sets a function to be called when a connection is closed
 func WithOnDisconnect(fn func(error)) ClientOption {
 	return func(c *Client) {
@@ -96,73 +206,36 @@ func WithOnDisconnect(fn func(error)) ClientOption {
 	}
 }
 
-// Handle registers a handler for a specific message type
-func (c *Client) Handle(msgType MessageType, handler HandlerFunc) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.handlers[msgType] = handler
+// Use adds middleware that wraps every registered handler
+func (c *Client) Use(middleware ...eventsub.Middleware) {
+	c.dispatcher.Use(middleware...)
 }
 
-// Handle registers a handler for a specific message type
-func (c *Client) HandleMessage(handler func(text string)) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.handlers["notification"] = func(ctx context.Context, data map[string]any) error {
-		// Extract metadata
-		metadata, ok := data["metadata"].(map[string]any)
-		if !ok {
-			logger.Error("metadata is not a map")
-			return nil
-		}
-
-		// An EventSub notification has occurred, such as channel.chat.message
-		subscriptionType, ok := metadata["subscription_type"].(string)
-		if !ok {
-			logger.Error("subscription_type is not a string")
-			return nil
-		}
-
-		switch subscriptionType {
-		case "channel.chat.message":
-			payload, ok := data["payload"].(map[string]any)
-			if !ok {
-				logger.Error("payload is not a map")
-				return nil
-			}
-
-			event, ok := payload["event"].(map[string]any)
-			if !ok {
-				logger.Error("event is not a map")
-				return nil
-			}
-
-			broadcasterUserLogin, _ := event["broadcaster_user_login"].(string)
-			chatterUserLogin, _ := event["chatter_user_login"].(string)
-
-			message, ok := event["message"].(map[string]any)
-			if !ok {
-				logger.Error("message is not a map")
-				return nil
-			}
-
-			text, ok := message["text"].(string)
-			if !ok {
-				logger.Error("text is not a string")
-				return nil
-			}
-
-			// First, print the message to the program's console
-			logger.Info("chat message received",
-				zap.String("channel", broadcasterUserLogin),
-				zap.String("user", chatterUserLogin),
-				zap.String("message", text),
-			)
-
-			handler(text)
-		}
-
-		return nil
-	}
+// Handle registers a handler for a specific message type. Handlers are
+// appended to the chain for that type rather than replacing earlier ones.
+func (c *Client) Handle(msgType eventsub.MessageType, handler eventsub.HandlerFunc, options ...eventsub.HandlerOption) eventsub.HandlerID {
+	return c.dispatcher.Add(string(msgType), handler, options...)
+}
+
+// HandleNotification registers a handler for notifications of a specific
+// EventSub subscription type, such as channel.chat.message
+func (c *Client) HandleNotification(subscriptionType string, handler eventsub.HandlerFunc, options ...eventsub.HandlerOption) eventsub.HandlerID {
+	return c.dispatcher.Add(eventsub.NotificationRoute(subscriptionType), handler, options...)
+}
+
+// HandleMessage registers a handler that receives every chat message
+func (c *Client) HandleMessage(handler func(ctx context.Context, msg *eventsub.ChannelChatMessage) error, options ...eventsub.HandlerOption) eventsub.HandlerID {
+	return c.HandleNotification(eventsub.SubscriptionChannelChatMessage, eventsub.EventHandler(
+		func(ctx context.Context, _ *eventsub.Message, event *eventsub.ChannelChatMessage) error {
+			return handler(ctx, event)
+		},
+	), options...)
+}
+
+// Unhandle removes a previously registered handler. It reports whether the
+// handler was found.
+func (c *Client) Unhandle(id eventsub.HandlerID) bool {
+	return c.dispatcher.Remove(id)
 }
 
 // // Handle registers a handler for a specific message type
@@ -172,44 +245,8 @@ func (c *Client) HandleMessage(handler func(text string)) {
 // 	c.handlers[msgType] = handler
 // }
 
-// Handle welcome message
-func (c *Client) HandleWelcome() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.welcomeHandler = func(ctx context.Context, data map[string]any) error {
-		// First message you get from the WebSocket server when connecting
-		payload, ok := data["payload"].(map[string]interface{})
-		if !ok {
-			logger.Error("payload is not a map")
-			return nil
-		}
-
-		fmt.Printf("payload: %v\n", payload)
-
-		session, ok := payload["session"].(map[string]interface{})
-		if !ok {
-			logger.Error("session is not a map")
-			return nil
-		}
-
-		sessionID, ok := session["id"].(string)
-		if !ok {
-			logger.Error("session ID is not a string")
-			return nil
-		}
-
-		// Register the Session ID it gives us
-		c.wsSessionId = sessionID
-
-		// Listen to EventSub, which joins the chatroom from your bot's account
-		registerEventSubListeners(c)
-
-		return nil
-	}
-}
-
 // HandleDefault sets a default handler for messages that don't match any specific type
-func (c *Client) HandleDefault(handler HandlerFunc) {
+func (c *Client) HandleDefault(handler eventsub.HandlerFunc) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.defaultHandler = handler
@@ -222,9 +259,6 @@ func (c *Client) Start() error {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
-	// Start goroutines for reading and writing
-	go c.readPump()
-
 	return nil
 }
 
@@ -235,226 +269,102 @@ func (c *Client) IsConnected() bool {
 	return c.connected
 }
 
-// Stop closes the WebSocket connection and stops all goroutines
-func (c *Client) Stop() {
-	c.cancel()
-	if c.conn != nil {
-		c.conn.Close()
-	}
+// DedupStats returns how many duplicate and stale messages were dropped
+func (c *Client) DedupStats() eventsub.DedupStats {
+	return c.dedup.Stats()
 }
 
-// connect establishes a WebSocket connection
-func (c *Client) connect() error {
-	conn, _, err := websocket.DefaultDialer.Dial(c.appConfig.EventsubWebsocketUrl, nil)
-	if err != nil {
-		return err
-	}
-
-	c.mu.Lock()
-	c.conn = conn
-	c.connected = true
-	c.mu.Unlock()
-
-	if c.onConnectFunc != nil {
-		c.onConnectFunc()
-	}
-
-	return nil
+// ProcessorStats returns a snapshot of the message queues
+func (c *Client) ProcessorStats() eventsub.ProcessorStats {
+	return c.processor.Stats()
 }
 
-// reconnect attempts to reconnect to the WebSocket server
-func (c *Client) reconnect() {
+// SetBotLogin sets the login chatters mention the bot by, e.g. the one
+// reported when the bot's token is validated
+func (c *Client) SetBotLogin(login string) {
 	c.mu.Lock()
-	c.connected = false
-	c.mu.Unlock()
-
-	for {
-		select {
-		case <-c.ctx.Done():
-			return
-		default:
-			log.Printf("Attempting to reconnect to %s in %v", c.appConfig.EventsubWebsocketUrl, c.reconnectDelay)
-			time.Sleep(c.reconnectDelay)
-
-			err := c.connect()
-			if err == nil {
-				log.Printf("Successfully reconnected to %s", c.appConfig.EventsubWebsocketUrl)
-				go c.readPump()
-				return
-			}
-
-			log.Printf("Failed to reconnect: %v", err)
-		}
-	}
+	defer c.mu.Unlock()
+	c.botLogin = login
 }
 
-// readPump handles reading messages from the WebSocket connection
-func (c *Client) readPump() {
-	defer func() {
-		c.mu.Lock()
-		c.connected = false
-		c.conn.Close()
-		c.mu.Unlock()
-
-		if c.onDisconnectFunc != nil {
-			c.onDisconnectFunc(nil)
-		}
-
-		if c.autoReconnect {
-			go c.reconnect()
-		}
-	}()
-
-	for {
-		_, message, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("read error: %v", err)
-			}
-			break
-		}
-
-		var data any
-		if err := json.Unmarshal(message, &data); err != nil {
-			logger.Error("error parsing message", zap.Error(err))
-			continue
-		}
-
-		go c.handleMessage(data)
-	}
+// BotLogin returns the login chatters mention the bot by
+func (c *Client) BotLogin() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.botLogin
 }
 
-// handleMessage processes incoming messages and routes them to the appropriate handler
-func (c *Client) handleMessage(data any) {
-	// Convert the generic interface{} to a map to access its fields
-	dataMap, ok := data.(map[string]any)
-	if !ok {
-		logger.Error("data is not a map")
-		return
-	}
-
-	// Extract metadata
-	metadata, ok := dataMap["metadata"].(map[string]any)
-	if !ok {
-		logger.Error("metadata is not a map")
-		return
-	}
-
-	// Get message type
-	metadataMsgType, ok := metadata["message_type"].(string)
-	if !ok {
-		logger.Error("message_type is not a string")
-		return
-	}
+// ChatStats returns a snapshot of the outbound chat queue
+func (c *Client) ChatStats() chat.QueueStats {
+	return c.outbox.Stats()
+}
 
-	msgType := MessageType(metadataMsgType)
+// PauseChat holds outgoing chat messages until ResumeChat
+func (c *Client) PauseChat() {
+	c.outbox.Pause()
+}
 
-	// Find the appropriate handler
-	c.mu.RLock()
-	handler, ok := c.handlers[msgType]
-	c.mu.RUnlock()
-
-	if ok {
-		// We have a specific handler for this message type
-		err := handler(c.ctx, dataMap)
-		if err != nil {
-			log.Printf("Error handling message of type %s: %v", msgType, err)
-		}
-	} else if c.welcomeHandler != nil && c.wsSessionId == "" {
-		c.welcomeHandler(c.ctx, dataMap)
-	} else if c.defaultHandler != nil {
-		// Use the default handler
-		c.defaultHandler(c.ctx, dataMap)
-	} else {
-		log.Printf("No handler found for message type: %s", msgType)
-	}
+// ResumeChat sends the chat messages held by PauseChat
+func (c *Client) ResumeChat() {
+	c.outbox.Resume()
 }
 
-func registerEventSubListeners(c *Client) (string, error) {
-	// Create the request body
-	requestBody := map[string]interface{}{
-		"type":    "channel.chat.message",
-		"version": "1",
-		"condition": map[string]string{
-			"broadcaster_user_id": c.appConfig.ChatChannelUserId,
-			"user_id":             c.appConfig.BotUserId,
-		},
-		"transport": map[string]string{
-			"method":     "websocket",
-			"session_id": c.wsSessionId,
-		},
-	}
+// Stop closes the WebSocket connection and stops all goroutines. Messages that
+// were already queued are still handled before Stop returns, and queued chat
+// messages are given a few seconds to be sent.
+func (c *Client) Stop() {
+	c.cancel()
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		logger.Error("error marshaling request body", zap.Error(err))
-		return "", err
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.close()
 	}
-
-	// Create the HTTP request
-	req, err := http.NewRequest("POST", "https://api.twitch.tv/helix/eventsub/subscriptions", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		logger.Error("error creating request", zap.Error(err))
-		return "", err
+	if c.pending != nil {
+		c.pending.close()
 	}
+	c.connected = false
+	c.mu.Unlock()
 
-	fmt.Printf("requestBody: %v\n", requestBody)
-
-	// Add headers
-	req.Header.Set("Authorization", "Bearer "+c.appConfig.OauthToken)
-	req.Header.Set("Client-Id", c.appConfig.ClientId)
-	req.Header.Set("Content-Type", "application/json")
+	c.processor.Stop()
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logger.Error("error sending request", zap.Error(err))
-		return "", err
-	}
-	defer resp.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), outboxDrainTimeout)
+	defer cancel()
+	c.outbox.Close(ctx)
+}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("error reading response body", zap.Error(err))
-		return "", err
-	}
+// handleMessage routes a decoded message to the appropriate handlers
+func (c *Client) handleMessage(msg *eventsub.Message) {
+	msgType := msg.Metadata.MessageType
 
-	// Check response status (202 Accepted is the expected response)
-	if resp.StatusCode != 202 {
-		logger.Error("failed to subscribe to channel.chat.message",
-			zap.Int("status", resp.StatusCode),
-			zap.String("response", string(body)),
+	// Twitch may redeliver notifications and handlers are not idempotent
+	if err := c.dedup.Check(msg.Metadata); err != nil {
+		logger.Debug("dropping message",
+			zap.String("message_id", msg.Metadata.MessageID),
+			zap.String("message_type", string(msgType)),
+			zap.Error(err),
 		)
-		return "", fmt.Errorf("failed to subscribe to channel.chat.message: status code %d", resp.StatusCode)
+		return
 	}
 
-	// Parse the response
-	var responseData map[string]interface{}
-	if err := json.Unmarshal(body, &responseData); err != nil {
-		logger.Error("error parsing response JSON", zap.Error(err))
-		return "", err
+	if msg.Revocation != nil {
+		c.handleRevocation(msg.Revocation)
 	}
 
-	// Extract subscription ID
-	dataArray, ok := responseData["data"].([]interface{})
-	if !ok || len(dataArray) == 0 {
-		logger.Error("unexpected response format")
-		return "", fmt.Errorf("unexpected response format")
+	// Generic handlers for the message type run first, then the ones registered
+	// for the subscription type of a notification
+	handled, err := c.dispatcher.DispatchMessage(c.ctx, msg)
+	if err != nil {
+		log.Printf("Error handling message of type %s: %v", msgType, err)
 	}
 
-	firstItem, ok := dataArray[0].(map[string]interface{})
-	if !ok {
-		logger.Error("unexpected data item format")
-		return "", fmt.Errorf("unexpected data item format")
+	if handled {
+		return
 	}
 
-	subscriptionID, ok := firstItem["id"].(string)
-	if !ok {
-		logger.Error("could not find subscription ID")
-		return "", fmt.Errorf("could not find subscription ID")
+	if c.defaultHandler != nil {
+		// Use the default handler
+		c.defaultHandler(c.ctx, msg)
+	} else {
+		log.Printf("No handler found for message type: %s", msgType)
 	}
-
-	return subscriptionID, nil
 }
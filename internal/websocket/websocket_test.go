@@ -0,0 +1,283 @@
+package websocket_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/internal/chat"
+	"github.com/OleksandrOleniuk/twitchong/internal/config"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub"
+	"github.com/OleksandrOleniuk/twitchong/internal/eventsub/eventsubtest"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix"
+	"github.com/OleksandrOleniuk/twitchong/internal/helix/helixtest"
+	"github.com/OleksandrOleniuk/twitchong/internal/websocket"
+)
+
+const timeout = 5 * time.Second
+
+var chatCondition = map[string]string{"broadcaster_user_id": "2", "user_id": "1"}
+
+// harness is a client connected to a fake EventSub server, reporting what it
+// does on channels
+type harness struct {
+	server     *eventsubtest.Server
+	client     *websocket.Client
+	subscribed chan []websocket.SubscriptionResult
+	lifecycle  chan eventsub.LifecycleEvent
+	chat       chan string
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	h := &harness{
+		server:     eventsubtest.NewServer(),
+		subscribed: make(chan []websocket.SubscriptionResult, 10),
+		lifecycle:  make(chan eventsub.LifecycleEvent, 10),
+		chat:       make(chan string, 10),
+	}
+
+	appConfig := &config.Config{
+		ClientId:             "client-id",
+		EventsubWebsocketUrl: h.server.URL(),
+		HelixBaseUrl:         h.server.HelixURL(),
+	}
+
+	h.client = websocket.New(appConfig,
+		websocket.WithHelix(helix.New(appConfig, helix.WithRetries(0, time.Millisecond))),
+		websocket.WithBackoff(websocket.ConstantBackoff{Delay: 10 * time.Millisecond}),
+		websocket.WithOnSubscribe(func(results []websocket.SubscriptionResult) { h.subscribed <- results }),
+		websocket.WithOnLifecycle(func(event eventsub.LifecycleEvent) {
+			if event.Type == eventsub.LifecycleRevoked {
+				h.lifecycle <- event
+			}
+		}),
+	)
+	h.client.HandleMessage(func(ctx context.Context, msg *eventsub.ChannelChatMessage) error {
+		h.chat <- msg.Message.Text
+		return nil
+	})
+	if err := h.client.Subscribe(eventsub.SubscriptionChannelChatMessage, "1", chatCondition); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.client.Stop()
+		h.server.Close()
+	})
+	return h
+}
+
+// session waits for the n-th session the server welcomed
+func (h *harness) session(t *testing.T, n int) *eventsubtest.Session {
+	t.Helper()
+	return waitForSession(t, h.server, n)
+}
+
+func waitForSession(t *testing.T, server *eventsubtest.Server, n int) *eventsubtest.Session {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	session, err := server.WaitForSession(ctx, n)
+	if err != nil {
+		t.Fatalf("waiting for session %d: %v", n, err)
+	}
+	return session
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+// expectNothing fails when a value arrives on ch within a short while
+func expectNothing[T any](t *testing.T, ch <-chan T, what string) {
+	t.Helper()
+	select {
+	case value := <-ch:
+		t.Fatalf("unexpected %s: %v", what, value)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func chatEvent(text string) map[string]any {
+	return map[string]any{
+		"broadcaster_user_id": "2",
+		"chatter_user_id":     "3",
+		"chatter_user_login":  "viewer",
+		"message_id":          text,
+		"message":             map[string]any{"text": text},
+	}
+}
+
+// enabledSubscriptions returns the server's enabled subscriptions
+func (h *harness) enabledSubscriptions() []eventsub.Subscription {
+	var enabled []eventsub.Subscription
+	for _, subscription := range h.server.Subscriptions() {
+		if subscription.Status == "enabled" {
+			enabled = append(enabled, subscription)
+		}
+	}
+	return enabled
+}
+
+func TestWelcomeSubscribes(t *testing.T) {
+	h := newHarness(t)
+	session := h.session(t, 1)
+
+	results := receive(t, h.subscribed, "subscriptions")
+	if len(results) != 1 || results[0].Err != nil || results[0].SessionID != session.ID {
+		t.Fatalf("subscription results %+v, want one on session %s", results, session.ID)
+	}
+
+	enabled := h.enabledSubscriptions()
+	if len(enabled) != 1 || enabled[0].Type != eventsub.SubscriptionChannelChatMessage || enabled[0].Transport.SessionID != session.ID {
+		t.Fatalf("server subscriptions %+v", enabled)
+	}
+
+	if _, err := session.SendNotification(eventsub.SubscriptionChannelChatMessage, chatEvent("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if text := receive(t, h.chat, "chat message"); text != "hello" {
+		t.Errorf("handled %q, want hello", text)
+	}
+}
+
+func TestRedeliveredNotificationIsHandledOnce(t *testing.T) {
+	h := newHarness(t)
+	session := h.session(t, 1)
+	receive(t, h.subscribed, "subscriptions")
+
+	session.SendNotificationWithID("message-1", eventsub.SubscriptionChannelChatMessage, chatEvent("first"))
+	session.SendNotificationWithID("message-1", eventsub.SubscriptionChannelChatMessage, chatEvent("first"))
+	session.SendNotificationWithID("message-2", eventsub.SubscriptionChannelChatMessage, chatEvent("second"))
+
+	// Messages are handled in order, so the redelivery was dropped before "second"
+	if text := receive(t, h.chat, "first message"); text != "first" {
+		t.Errorf("handled %q, want first", text)
+	}
+	if text := receive(t, h.chat, "second message"); text != "second" {
+		t.Errorf("handled %q, want second", text)
+	}
+	if stats := h.client.DedupStats(); stats.Duplicates != 1 {
+		t.Errorf("dedup stats %+v, want 1 duplicate", stats)
+	}
+}
+
+func TestSessionReconnectHandOff(t *testing.T) {
+	h := newHarness(t)
+	old := h.session(t, 1)
+	receive(t, h.subscribed, "subscriptions")
+
+	if err := old.SendReconnect(); err != nil {
+		t.Fatal(err)
+	}
+	next := h.session(t, 2)
+
+	// The client closes the old socket once the new session is welcomed
+	select {
+	case <-old.Done():
+	case <-time.After(timeout):
+		t.Fatal("old session was not closed after the hand-off")
+	}
+
+	// Subscriptions carry over, so nothing is created again
+	expectNothing(t, h.subscribed, "subscription on the new session")
+	enabled := h.enabledSubscriptions()
+	if len(enabled) != 1 || enabled[0].Transport.SessionID != next.ID {
+		t.Fatalf("server subscriptions %+v, want one on session %s", enabled, next.ID)
+	}
+
+	if _, err := next.SendNotification(eventsub.SubscriptionChannelChatMessage, chatEvent("after hand-off")); err != nil {
+		t.Fatal(err)
+	}
+	if text := receive(t, h.chat, "chat message"); text != "after hand-off" {
+		t.Errorf("handled %q, want after hand-off", text)
+	}
+}
+
+func TestResubscribeAfterDrop(t *testing.T) {
+	h := newHarness(t)
+	first := h.session(t, 1)
+	receive(t, h.subscribed, "subscriptions")
+
+	// Twitch ends the session without a reconnect message
+	first.Close()
+	second := h.session(t, 2)
+
+	results := receive(t, h.subscribed, "subscriptions on the new session")
+	if len(results) != 1 || results[0].Err != nil || results[0].SessionID != second.ID {
+		t.Fatalf("subscription results %+v, want one on session %s", results, second.ID)
+	}
+
+	enabled := h.enabledSubscriptions()
+	if len(enabled) != 1 || enabled[0].Transport.SessionID != second.ID {
+		t.Fatalf("server subscriptions %+v, want one on session %s", enabled, second.ID)
+	}
+
+	if _, err := second.SendNotification(eventsub.SubscriptionChannelChatMessage, chatEvent("reconnected")); err != nil {
+		t.Fatal(err)
+	}
+	if text := receive(t, h.chat, "chat message"); text != "reconnected" {
+		t.Errorf("handled %q, want reconnected", text)
+	}
+}
+
+func TestRevokedSubscriptionIsNotRecreated(t *testing.T) {
+	h := newHarness(t)
+	first := h.session(t, 1)
+	receive(t, h.subscribed, "subscriptions")
+
+	if err := first.SendRevocation(eventsub.SubscriptionChannelChatMessage, eventsub.RevocationAuthorizationRevoked); err != nil {
+		t.Fatal(err)
+	}
+
+	event := receive(t, h.lifecycle, "revocation")
+	if event.Request.Type != eventsub.SubscriptionChannelChatMessage || event.Reason != eventsub.RevocationAuthorizationRevoked {
+		t.Errorf("lifecycle event %+v", event)
+	}
+	if requests := h.client.Subscriptions(); len(requests) != 0 {
+		t.Errorf("client still keeps %+v", requests)
+	}
+
+	// A fresh session no longer subscribes to the revoked type
+	first.Close()
+	h.session(t, 2)
+	if results := receive(t, h.subscribed, "subscriptions on the new session"); len(results) != 0 {
+		t.Errorf("subscription results %+v, want none", results)
+	}
+	if enabled := h.enabledSubscriptions(); len(enabled) != 0 {
+		t.Errorf("server subscriptions %+v, want none", enabled)
+	}
+}
+
+func TestReplyFromPlainClient(t *testing.T) {
+	fake := helixtest.NewServer()
+	defer fake.Close()
+	appConfig := &config.Config{ClientId: "client-id", BotUserId: "1"}
+	fake.Configure(appConfig)
+
+	// Not built by NewTwitchChat, the client still has a chat queue
+	client := websocket.New(appConfig)
+	msg := &eventsub.ChannelChatMessage{BroadcasterUserID: "2", MessageID: "question"}
+	if err := client.Reply(msg, "answer", chat.PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	client.Stop()
+
+	messages := fake.Messages()
+	if len(messages) != 1 || messages[0].Message != "answer" || messages[0].ReplyParentMessageID != "question" || messages[0].SenderID != "1" {
+		t.Errorf("sent %+v, want the reply as the bot", messages)
+	}
+}
@@ -2,18 +2,88 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
+// Defaults applied when a RequestConfig leaves the field zero. SendRequest
+// and SendRequestAndParseResponse have no timeout unless one is set.
+const (
+	DefaultTimeout         = 30 * time.Second
+	DefaultMaxResponseSize = 10 << 20
+)
+
+// ErrResponseTooLarge is returned when a response body exceeds MaxResponseSize
+var ErrResponseTooLarge = errors.New("response body too large")
+
 // RequestConfig holds the configuration for making an HTTP request
 type RequestConfig struct {
 	Method  string            // HTTP method (GET, POST, etc.)
 	URL     string            // Request URL
 	Headers map[string]string // Request headers
 	Body    interface{}       // Request body (will be JSON marshaled)
+
+	Timeout         time.Duration // Whole request timeout, including reading the body (default DefaultTimeout for the Context functions)
+	Client          *http.Client  // Client to send the request with (default http.DefaultClient)
+	Retry           *RetryPolicy  // Retries failed requests when set
+	MaxResponseSize int64         // Response body limit of SendRequestAndParseResponse (default DefaultMaxResponseSize)
+}
+
+// HTTPError is returned by SendRequestAndParseResponse for responses with a
+// status of 400 or above
+type HTTPError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, string(e.Body))
+}
+
+// RetryPolicy retries requests that failed to send or got a 429 or 5xx
+// response, doubling the delay after every attempt
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts including the first one
+	Delay       time.Duration // Delay before the first retry
+	MaxDelay    time.Duration // Upper bound of the delay, 0 for none
+}
+
+// shouldRetry reports whether a request should be sent again
+func (p *RetryPolicy) shouldRetry(attempt int, resp *http.Response, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// delay returns the wait before the given retry, counting from 1
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.Delay << (attempt - 1)
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// cancelOnClose releases the request's timeout once the body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
 
 // SendRequest sends an HTTP request based on the provided configuration
@@ -36,40 +106,92 @@ type RequestConfig struct {
 //	defer resp.Body.Close()
 //	// Process response...
 func SendRequest(config RequestConfig) (*http.Response, error) {
-	// Create request body if provided
-	var body io.Reader
+	return sendRequest(context.Background(), config, 0)
+}
+
+// SendRequestContext is SendRequest bounded by ctx and config.Timeout. The
+// timeout also covers reading the body, which is released by closing it.
+func SendRequestContext(ctx context.Context, config RequestConfig) (*http.Response, error) {
+	return sendRequest(ctx, config, DefaultTimeout)
+}
+
+// sendRequest sends the request, retrying as config.Retry allows. A zero
+// config.Timeout falls back to defaultTimeout, and 0 for both means none.
+func sendRequest(ctx context.Context, config RequestConfig, defaultTimeout time.Duration) (*http.Response, error) {
+	// Marshal the body once, it is sent again on every retry
+	var jsonData []byte
 	if config.Body != nil {
-		jsonData, err := json.Marshal(config.Body)
+		var err error
+		jsonData, err = json.Marshal(config.Body)
 		if err != nil {
 			return nil, fmt.Errorf("error marshaling request body: %w", err)
 		}
-		body = bytes.NewBuffer(jsonData)
 	}
 
-	// Create the request
-	req, err := http.NewRequest(config.Method, config.URL, body)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
 	}
-
-	// Set headers
-	for key, value := range config.Headers {
-		req.Header.Set(key, value)
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
 	}
 
-	// If body is JSON and Content-Type is not set, set it to application/json
-	if config.Body != nil && req.Header.Get("Content-Type") == "" {
-		req.Header.Set("Content-Type", "application/json")
+	client := config.Client
+	if client == nil {
+		client = http.DefaultClient
 	}
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
-	}
+	for attempt := 1; ; attempt++ {
+		var body io.Reader
+		if jsonData != nil {
+			body = bytes.NewReader(jsonData)
+		}
 
-	return resp, nil
+		// Create the request
+		req, err := http.NewRequestWithContext(ctx, config.Method, config.URL, body)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+
+		// Set headers
+		for key, value := range config.Headers {
+			req.Header.Set(key, value)
+		}
+
+		// If body is JSON and Content-Type is not set, set it to application/json
+		if config.Body != nil && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		// Send the request
+		resp, err := client.Do(req)
+		if !config.Retry.shouldRetry(attempt, resp, err) {
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("error sending request: %w", err)
+			}
+			resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, DefaultMaxResponseSize))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(config.Retry.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			cancel()
+			return nil, fmt.Errorf("error sending request: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
 }
 
 // SendRequestAndParseResponse sends an HTTP request and parses the response into the provided result.
@@ -119,21 +241,40 @@ func SendRequest(config RequestConfig) (*http.Response, error) {
 //     }
 //     err := SendRequestAndParseResponse(config, &result)
 func SendRequestAndParseResponse(config RequestConfig, result any) error {
-	resp, err := SendRequest(config)
+	return sendAndParse(context.Background(), config, result, 0)
+}
+
+// SendRequestAndParseResponseContext is SendRequestAndParseResponse bounded by
+// ctx and config.Timeout. Responses with a status of 400 or above are returned
+// as *HTTPError.
+func SendRequestAndParseResponseContext(ctx context.Context, config RequestConfig, result any) error {
+	return sendAndParse(ctx, config, result, DefaultTimeout)
+}
+
+func sendAndParse(ctx context.Context, config RequestConfig, result any, defaultTimeout time.Duration) error {
+	resp, err := sendRequest(ctx, config, defaultTimeout)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	maxSize := config.MaxResponseSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxResponseSize
+	}
+
+	// Read response body, one byte past the limit tells it was exceeded
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
 	if err != nil {
 		return fmt.Errorf("error reading response body: %w", err)
 	}
+	if int64(len(body)) > maxSize {
+		return fmt.Errorf("%w: over %d bytes", ErrResponseTooLarge, maxSize)
+	}
 
 	// Check if response is successful
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		return &HTTPError{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
 	}
 
 	// Parse response if result is provided
@@ -0,0 +1,189 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OleksandrOleniuk/twitchong/pkg/utils"
+)
+
+// failingServer answers the first failures requests with status, then 200
+// with the request body. It counts every request.
+func failingServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestSendRequestContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	resp, err := utils.SendRequestContext(context.Background(), utils.RequestConfig{
+		Method:  http.MethodPost,
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Body:    map[string]string{"name": "example"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != `{"name":"example"}` {
+		t.Errorf("got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	retry := &utils.RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}
+
+	tests := []struct {
+		name     string
+		failures int32
+		status   int
+		wantErr  int // status of the HTTPError, 0 for success
+		requests int32
+	}{
+		{"recovers from 5xx", 2, http.StatusServiceUnavailable, 0, 3},
+		{"recovers from 429", 1, http.StatusTooManyRequests, 0, 2},
+		{"gives up after MaxAttempts", 5, http.StatusInternalServerError, http.StatusInternalServerError, 3},
+		{"does not retry 4xx", 1, http.StatusBadRequest, http.StatusBadRequest, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := failingServer(t, tt.failures, tt.status)
+
+			var result map[string]string
+			err := utils.SendRequestAndParseResponseContext(context.Background(), utils.RequestConfig{
+				Method: http.MethodPost,
+				URL:    server.URL,
+				Body:   map[string]string{"name": "example"},
+				Retry:  retry,
+			}, &result)
+
+			var httpErr *utils.HTTPError
+			switch {
+			case tt.wantErr == 0 && err != nil:
+				t.Fatal(err)
+			case tt.wantErr == 0 && result["name"] != "example":
+				// The body is sent again on every attempt
+				t.Errorf("result %v, want the request body", result)
+			case tt.wantErr != 0 && (!errors.As(err, &httpErr) || httpErr.StatusCode != tt.wantErr):
+				t.Errorf("got %v, want an HTTPError with status %d", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("sent %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"` + strings.Repeat("a", 100) + `"`))
+	}))
+	defer server.Close()
+
+	var result string
+	config := utils.RequestConfig{Method: http.MethodGet, URL: server.URL, MaxResponseSize: 10}
+	if err := utils.SendRequestAndParseResponseContext(context.Background(), config, &result); !errors.Is(err, utils.ErrResponseTooLarge) {
+		t.Errorf("got %v, want ErrResponseTooLarge", err)
+	}
+
+	config.MaxResponseSize = 102
+	if err := utils.SendRequestAndParseResponseContext(context.Background(), config, &result); err != nil || len(result) != 100 {
+		t.Errorf("body at the limit: got %d bytes, %v", len(result), err)
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"not found"}`))
+	}))
+	defer server.Close()
+
+	err := utils.SendRequestAndParseResponse(utils.RequestConfig{Method: http.MethodGet, URL: server.URL}, nil)
+
+	var httpErr *utils.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("got %v, want an HTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusNotFound || string(httpErr.Body) != `{"message":"not found"}` || httpErr.Header.Get("Retry-After") != "5" {
+		t.Errorf("got %+v", httpErr)
+	}
+	if !strings.Contains(err.Error(), "404") {
+		t.Errorf("message %q does not name the status", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-body" {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := utils.RequestConfig{Method: http.MethodGet, URL: server.URL, Timeout: 50 * time.Millisecond}
+	if _, err := utils.SendRequestContext(context.Background(), config); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow response: got %v, want deadline exceeded", err)
+	}
+
+	// The timeout also covers reading the body
+	config.URL = server.URL + "/slow-body"
+	resp, err := utils.SendRequestContext(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow body: got %v, want deadline exceeded", err)
+	}
+
+	// Retries stop at the timeout too
+	failing, _ := failingServer(t, 100, http.StatusServiceUnavailable)
+	config = utils.RequestConfig{
+		Method:  http.MethodGet,
+		URL:     failing.URL,
+		Timeout: 50 * time.Millisecond,
+		Retry:   &utils.RetryPolicy{MaxAttempts: 100, Delay: time.Second},
+	}
+	if _, err := utils.SendRequestContext(context.Background(), config); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("retries: got %v, want deadline exceeded", err)
+	}
+}